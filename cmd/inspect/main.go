@@ -11,6 +11,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 
 	"github.com/square/inspect/cmd/inspect/osmain"
 	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/container"
 )
 
 func main() {
@@ -29,6 +31,11 @@ func main() {
 	var batchmode, servermode bool
 	var address string
 	var stepSec int
+	var smapsSec int
+	var pss bool
+	var groupBy string
+	var containerRoot string
+	var fsTypes, fsSkipTypes, fsMounts, fsSkipMounts string
 	var nIter int
 	var evt <-chan termui.Event
 	var widgets *osmain.DisplayWidgets
@@ -46,6 +53,50 @@ func main() {
 		"address to listen on for http if running in server mode")
 	flag.IntVar(&stepSec, "step", 2,
 		"metrics are collected every step seconds")
+	flag.Float64Var(&osmain.LimitUsagePct, "limitpct", osmain.LimitUsagePct,
+		"report processes using more than this percentage of a resource limit")
+	flag.Float64Var(&osmain.AcceptQueuePct, "acceptqpct", osmain.AcceptQueuePct,
+		"report listening sockets with accept queue above this percentage of backlog")
+	flag.IntVar(&osmain.CloseWaitThreshold, "closewait", osmain.CloseWaitThreshold,
+		"report processes holding more than these many sockets in CLOSE_WAIT")
+	flag.Float64Var(&osmain.DiskAwaitMsecs, "diskawait", osmain.DiskAwaitMsecs,
+		"report disks taking longer than these many milliseconds on average to serve requests")
+	flag.Float64Var(&osmain.DiskQueueSize, "diskqueue", osmain.DiskQueueSize,
+		"report disks with more than these many requests queued on average")
+	flag.BoolVar(&osmain.DiskPartitions, "diskpartitions", false,
+		"collect disk IO statistics for partitions")
+	flag.BoolVar(&osmain.DiskDeviceMapper, "diskdm", false,
+		"collect disk IO statistics for device mapper targets (LVM, LUKS...)")
+	flag.StringVar(&fsTypes, "fstypes", "",
+		"comma separated filesystem types to report usage for; default all but excluded")
+	flag.StringVar(&fsSkipTypes, "fsskiptypes", "",
+		"comma separated filesystem types to skip; default skips pseudo filesystems")
+	flag.StringVar(&fsMounts, "fsmounts", "",
+		"comma separated mountpoint patterns to report usage for; default all but excluded")
+	flag.StringVar(&fsSkipMounts, "fsskipmounts", "",
+		"comma separated mountpoint patterns to skip; default skips /proc, /sys, /run and container storage")
+	flag.Float64Var(&osmain.IRQImbalance, "irqimbalance", osmain.IRQImbalance,
+		"report busy interrupts concentrated on few CPUs above this imbalance score (0-1)")
+	flag.Float64Var(&osmain.BlockedTasks, "blocked", osmain.BlockedTasks,
+		"report more than these many threads staying in uninterruptible sleep (D)")
+	flag.StringVar(&osmain.KernelLogRules, "kmsgrules", "",
+		"configuration file with a [kmsg] section of \"class = pattern\" rules classifying kernel messages")
+	flag.DurationVar(&osmain.KernelEventWindow, "kmsgwindow", osmain.KernelEventWindow,
+		"report kernel messages matching rules as problems for this long")
+	flag.DurationVar(&osmain.FSFullHorizon, "fshorizon", osmain.FSFullHorizon,
+		"report filesystems forecast to fill up within this duration")
+	flag.IntVar(&smapsSec, "smaps", 0,
+		"collect proportional memory usage (PSS) of processes every smaps seconds; expensive, 0 disables")
+	flag.BoolVar(&pss, "pss", false,
+		"list processes by PSS instead of RSS; requires -smaps")
+	flag.StringVar(&osmain.ThreadProcesses, "threads", "",
+		"comma separated pids, topN (say top3) and command patterns whose threads are tracked")
+	flag.StringVar(&osmain.WatchRules, "watch", "",
+		"configuration file with a section per process expected to be running (comm, cmdline, user, cgroup, min, max, max_cpu, max_rss)")
+	flag.StringVar(&groupBy, "group", "",
+		"aggregate process lists by one of comm, user, parent, session, cgroup or container")
+	flag.StringVar(&containerRoot, "containerroot", "/",
+		"directory under which container runtime and kubelet metadata is found")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Options \n")
 		fmt.Fprintf(os.Stderr, "------- \n")
@@ -80,12 +131,21 @@ func main() {
 	// Default step for collectors
 	step := time.Millisecond * time.Duration(stepSec) * 1000
 	// Register various stats we are interested in tracking
+	container.DefaultResolver = container.NewResolver(containerRoot)
+	osmain.SmapsInterval = time.Second * time.Duration(smapsSec)
+	osmain.FSIncludeTypes = splitList(fsTypes)
+	osmain.FSExcludeTypes = splitList(fsSkipTypes)
+	osmain.FSIncludeMountpoints = splitList(fsMounts)
+	osmain.FSExcludeMountpoints = splitList(fsSkipMounts)
 	stats := osmain.Register(m, step)
+	stats.SetMemoryByPSS(pss)
+	stats.SetGroupBy(groupBy)
 	// run http server
 	if servermode {
 		go func() {
 			http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
 			http.HandleFunc("/api/v1/metrics.json", m.HttpJsonHandler)
+			http.HandleFunc("/api/v1/events.json", stats.EventsJSONHandler)
 			log.Fatal(http.ListenAndServe(address, nil))
 		}()
 	}
@@ -128,13 +188,13 @@ func main() {
 					uiDetailList = widgets.ProcessesByCPU
 					termui.Body = uiDetail(uiDetailList)
 				case 'd':
-					uiDetailList = widgets.DiskIOUsage
+					uiDetailList = widgets.DiskIODetail
 					termui.Body = uiDetail(uiDetailList)
 				case 'C':
 					uiDetailList = widgets.CgroupsCPU
 					termui.Body = uiDetail(uiDetailList)
 				case 'f':
-					uiDetailList = widgets.FileSystemUsage
+					uiDetailList = widgets.FileSystemDetail
 					termui.Body = uiDetail(uiDetailList)
 				case 'm':
 					uiDetailList = widgets.ProcessesByMemory
@@ -142,15 +202,51 @@ func main() {
 				case 'p':
 					uiDetailList = widgets.Problems
 					termui.Body = uiDetail(uiDetailList)
+				case 'P':
+					stats.SetMemoryByPSS(!stats.MemoryByPSS())
+				case 'g':
+					stats.SetGroupBy(uiNextGrouping(stats.GroupBy()))
 				case 'M':
 					uiDetailList = widgets.CgroupsMem
 					termui.Body = uiDetail(uiDetailList)
+				case 'D':
+					uiDetailList = widgets.ProcessStates
+					termui.Body = uiDetail(uiDetailList)
+				case 'x':
+					uiDetailList = widgets.ProcessChurn
+					termui.Body = uiDetail(uiDetailList)
+				case 'j':
+					stats.SelectProcess(1)
+				case 'k':
+					stats.SelectProcess(-1)
+				case 'T':
+					stats.DrillDown()
+					uiDetailList = widgets.ProcessThreads
+					termui.Body = uiDetail(uiDetailList)
+				case 'N':
+					uiDetailList = widgets.MemoryNodes
+					termui.Body = uiDetail(uiDetailList)
+				case 'S':
+					uiDetailList = widgets.SlabCaches
+					termui.Body = uiDetail(uiDetailList)
 				case 'n':
-					uiDetailList = widgets.InterfaceUsage
+					uiDetailList = widgets.InterfaceDetail
 					termui.Body = uiDetail(uiDetailList)
 				case 'i':
 					uiDetailList = widgets.ProcessesByIO
 					termui.Body = uiDetail(uiDetailList)
+				case 'r':
+					uiDetailList = widgets.ProcessesByRunq
+					termui.Body = uiDetail(uiDetailList)
+				case 'u':
+					uiDetailList = widgets.CPUDetail
+					termui.Body = uiDetail(uiDetailList)
+				case 'I':
+					uiDetailList = widgets.Interrupts
+					termui.Body = uiDetail(uiDetailList)
+				case 't':
+					uiDetailList = widgets.TCPSockets
+					termui.Body = uiDetail(uiDetailList)
 				case 's':
 					uiResetAttributes(widgets)
 					termui.Body = uiSummaryBody
@@ -166,3 +262,12 @@ func main() {
 	}
 	wg.Wait()
 }
+
+// splitList splits a comma separated option, returning nil if the
+// option was not set
+func splitList(option string) []string {
+	if option == "" {
+		return nil
+	}
+	return strings.Split(option, ",")
+}
@@ -1,7 +1,10 @@
 package osmain
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gizak/termui"
@@ -22,18 +25,101 @@ type osIndependentStats struct {
 // Number of Pids(in future cgroups etc) to display for top-N metrics
 const MaxEntries = 15
 
+// LimitUsagePct is the percentage of a resource limit (say open files)
+// above which a process is reported as a problem
+var LimitUsagePct = 80.0
+
+// SmapsInterval is how often proportional memory usage of processes is
+// collected where supported. Zero disables collection.
+var SmapsInterval time.Duration
+
+// AcceptQueuePct is the percentage of backlog of a listening socket
+// above which its accept queue is reported as a problem
+var AcceptQueuePct = 75.0
+
+// CloseWaitThreshold is the number of sockets in CLOSE_WAIT a process
+// may hold before it is reported as a problem
+var CloseWaitThreshold = 100
+
+// DiskAwaitMsecs is the average time in milliseconds for requests to a
+// disk to be served above which the disk is reported as a problem
+var DiskAwaitMsecs = 100.0
+
+// DiskQueueSize is the average number of requests queued or in flight
+// on a disk above which the disk is reported as a problem
+var DiskQueueSize = 32.0
+
+// DiskPartitions and DiskDeviceMapper enable collection of disk IO
+// statistics for partitions and device mapper targets (LVM, LUKS...)
+// in addition to whole disks where supported
+var DiskPartitions, DiskDeviceMapper bool
+
+// FSIncludeTypes, FSExcludeTypes, FSIncludeMountpoints and
+// FSExcludeMountpoints override the default selection of filesystems
+// usage is reported for where supported. Nil keeps the default.
+var FSIncludeTypes, FSExcludeTypes, FSIncludeMountpoints, FSExcludeMountpoints []string
+
+// FSFullHorizon is the time within which a filesystem is forecast to
+// fill up at its current growth rate to be reported as a problem
+var FSFullHorizon = 4 * time.Hour
+
+// IRQImbalance is the imbalance score (0 when interrupts are spread
+// evenly across CPUs, 1 when a single CPU handles all of them) above
+// which busy interrupts are reported as a problem
+var IRQImbalance = 0.5
+
+// IRQRate is the number of interrupts/s below which interrupts are not
+// considered busy enough to report imbalance for
+var IRQRate = 1000.0
+
+// BlockedTasks is the number of threads in uninterruptible sleep (D)
+// over the last few collections above which it is reported as a problem
+var BlockedTasks = 5.0
+
+// KernelLogRules is a configuration file with rules classifying kernel
+// messages where supported. Empty keeps the default rules.
+var KernelLogRules string
+
+// KernelEventWindow is how long kernel messages matching a rule are
+// reported as problems
+var KernelEventWindow = 15 * time.Minute
+
+// ThreadProcesses is a comma separated list of pids, topN and command
+// patterns whose threads are tracked where supported
+var ThreadProcesses string
+
+// WatchRules is a configuration file with rules describing processes
+// expected to be running where supported. Empty disables the check.
+var WatchRules string
+
+// ProcessGroupings lists names accepted by Stats.GroupBy to aggregate
+// per-process lists where supported
+var ProcessGroupings = []string{"comm", "user", "parent", "session", "cgroup", "container"}
+
 // DisplayWidgets represents various variables used for display
 // Perhaps this belongs to main package
 type DisplayWidgets struct {
 	Summary           *termui.Par
 	ProcessesByCPU    *termui.List
+	CPUDetail         *termui.List
 	ProcessesByMemory *termui.List
 	ProcessesByIO     *termui.List
+	ProcessesByRunq   *termui.List
+	ProcessStates     *termui.List
+	ProcessChurn      *termui.List
+	ProcessThreads    *termui.List
+	TCPSockets        *termui.List
 	DiskIOUsage       *termui.List
+	DiskIODetail      *termui.List
 	FileSystemUsage   *termui.List
+	FileSystemDetail  *termui.List
 	InterfaceUsage    *termui.List
+	InterfaceDetail   *termui.List
+	Interrupts        *termui.List
 	CgroupsCPU        *termui.List
 	CgroupsMem        *termui.List
+	MemoryNodes       *termui.List
+	SlabCaches        *termui.List
 	Problems          *termui.List
 }
 
@@ -44,6 +130,12 @@ type Stats struct {
 	ProcessStat *pidstat.ProcessStat
 	Problems    []string // various problems spotted
 	OsSpecific  interface{}
+	memoryByPSS bool       // list processes by PSS instead of RSS
+	groupBy     string     // aggregate processes by one of ProcessGroupings
+	selected    int        // row of the cpu list selected for drill-down
+	threadsOf   string     // pid of the process whose threads are shown
+	selectedPid string     // pid on the selected row when last printed
+	mu          sync.Mutex // protects options changed while printing
 }
 
 // Register starts metrics collection for all available metrics
@@ -54,9 +146,10 @@ func Register(m *metrics.MetricContext, step time.Duration) *Stats {
 	stats.MemStat = memstat.New(m, step)
 	p := pidstat.NewProcessStat(m, step)
 	// Filter processes which have < 1% of a CPU or < 1% memory
+	// unless they are interesting for OS specific reasons
 	p.SetPidFilter(pidstat.PidFilterFunc(func(p *pidstat.PerProcessStat) bool {
 		memUsagePct := (p.MemUsage() / stats.MemStat.Total()) * 100.0
-		if p.CPUUsage() > 0.01 || memUsagePct > 1 {
+		if p.CPUUsage() > 0.01 || memUsagePct > 1 || osSpecificPidFilter(p) {
 			return true
 		}
 		return false
@@ -77,13 +170,13 @@ func (stats *Stats) Print(batchmode bool, layout *DisplayWidgets) {
 	cpuPctUsage := (stats.CPUStat.Usage() / stats.CPUStat.Total()) * 100
 	cpuUserspacePctUsage := (stats.CPUStat.UserSpace() / stats.CPUStat.Total()) * 100
 	cpuKernelPctUsage := (stats.CPUStat.Kernel() / stats.CPUStat.Total()) * 100
-	// Top processes by usage
-	procsByCPUUsage := stats.ProcessStat.ByCPUUsage()
-	procsByMemUsage := stats.ProcessStat.ByMemUsage()
+	cpuIowaitPct := (stats.CPUStat.Iowait() / stats.CPUStat.Total()) * 100
+	cpuStealPct := (stats.CPUStat.Steal() / stats.CPUStat.Total()) * 100
 	// summary
 	summaryLine := fmt.Sprintf(
-		"total: cpu: %3.1f%% user: %3.1f%%, kernel: %3.1f%%, mem: %3.1f%%",
-		cpuPctUsage, cpuUserspacePctUsage, cpuKernelPctUsage, memPctUsage)
+		"total: cpu: %3.1f%% user: %3.1f%%, kernel: %3.1f%%, iowait: %3.1f%%, steal: %3.1f%%, mem: %3.1f%%",
+		cpuPctUsage, cpuUserspacePctUsage, cpuKernelPctUsage, cpuIowaitPct,
+		cpuStealPct, memPctUsage) + memorySummaryOsSpecific(stats.OsSpecific)
 	displayLine(batchmode, "summary", layout, summaryLine)
 	if cpuPctUsage > 80.0 {
 		stats.Problems = append(stats.Problems, "CPU usage is > 80%")
@@ -91,25 +184,133 @@ func (stats *Stats) Print(batchmode bool, layout *DisplayWidgets) {
 	if cpuKernelPctUsage > 30.0 {
 		stats.Problems = append(stats.Problems, "CPU usage in kernel is > 30%")
 	}
+	if cpuIowaitPct > 20.0 {
+		stats.Problems = append(stats.Problems, "CPU time waiting for IO is > 20%")
+	}
+	// noisy neighbours on a virtual machine
+	if cpuStealPct > 10.0 {
+		stats.Problems = append(stats.Problems, "CPU time stolen by hypervisor is > 10%")
+	}
 	if memPctUsage > 80.0 {
 		stats.Problems = append(stats.Problems, "Memory usage > 80%")
 	}
+	// Top processes (or groups of processes) by usage
+	if printProcessGroups(batchmode, layout, stats) {
+		// rows are groups; there is no process to drill into
+		stats.setSelectedPid("")
+	} else {
+		stats.printProcesses(batchmode, layout)
+	}
+	printOsSpecific(batchmode, layout, stats.OsSpecific)
+	// finally deal with problems
+	displayList(batchmode, "problem", layout, stats.Problems)
+}
+
+// SetMemoryByPSS lists processes by PSS instead of RSS
+func (stats *Stats) SetMemoryByPSS(pss bool) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.memoryByPSS = pss
+}
+
+// MemoryByPSS returns true if processes are listed by PSS
+func (stats *Stats) MemoryByPSS() bool {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.memoryByPSS
+}
+
+// SetGroupBy aggregates processes by one of ProcessGroupings; anything
+// else lists processes individually
+func (stats *Stats) SetGroupBy(group string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.groupBy = group
+}
+
+// GroupBy returns the grouping processes are aggregated by
+func (stats *Stats) GroupBy() string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.groupBy
+}
+
+// SelectProcess moves selection in the per-process cpu list by delta rows
+func (stats *Stats) SelectProcess(delta int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.selected += delta
+	if stats.selected < 0 {
+		stats.selected = 0
+	}
+	if stats.selected >= MaxEntries {
+		stats.selected = MaxEntries - 1
+	}
+}
+
+// DrillDown shows threads of the process selected in the cpu list.
+// Nothing is selected while processes are grouped.
+func (stats *Stats) DrillDown() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.threadsOf = stats.selectedPid
+}
+
+// ThreadsOf returns pid of the process drilled into, if any
+func (stats *Stats) ThreadsOf() string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.threadsOf
+}
+
+// EventsJSONHandler exposes counts and most recent kernel messages
+// matching rules as JSON over HTTP
+func (stats *Stats) EventsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eventsOsSpecific(stats.OsSpecific))
+}
+
+// printProcesses prints top processes by cpu and memory usage
+func (stats *Stats) printProcesses(batchmode bool, layout *DisplayWidgets) {
+	procsByCPUUsage := stats.ProcessStat.ByCPUUsage()
+	procsByMemUsage := stats.ProcessStat.ByMemUsage()
+	memUsage := (*pidstat.PerProcessStat).MemUsage
+	memLabel := "Memory(m)"
+	if stats.MemoryByPSS() {
+		if procs, usage, ok := processesByPSS(stats); ok {
+			procsByMemUsage, memUsage = procs, usage
+			memLabel = "Memory(PSS)(m)"
+		}
+	}
 	// Processes by cpu usage
 	var cpu []string
 	n := MaxEntries
 	if len(procsByCPUUsage) < MaxEntries {
 		n = len(procsByCPUUsage)
 	}
+	selected := stats.selectedRow()
+	var selectedPid string
 	for i := 0; i < n; i++ {
 		cpuUsagePct := (procsByCPUUsage[i].CPUUsage() / stats.CPUStat.Total()) * 100
-		cpu = append(cpu, fmt.Sprintf("%5s %10s %10s %8s", fmt.Sprintf("%3.1f%%", cpuUsagePct),
+		line := fmt.Sprintf("%5s %10s %10s %8s", fmt.Sprintf("%3.1f%%", cpuUsagePct),
 			truncate(procsByCPUUsage[i].Comm(), 10),
 			truncate(procsByCPUUsage[i].User(), 10),
-			procsByCPUUsage[i].Pid()))
+			procsByCPUUsage[i].Pid())
+		// mark the row threads are shown for by DrillDown
+		if !batchmode {
+			if i == selected {
+				selectedPid = procsByCPUUsage[i].Pid()
+				line = ">" + line
+			} else {
+				line = " " + line
+			}
+		}
+		cpu = append(cpu, line)
 	}
 	for i := n; i < MaxEntries; i++ {
 		cpu = append(cpu, fmt.Sprintf("%5s %10s %10s %8s", "-", "-", "-", "-"))
 	}
+	stats.setSelectedPid(selectedPid)
 	displayList(batchmode, "cpu", layout, cpu)
 	// Top processes by mem
 	var mem []string
@@ -119,7 +320,7 @@ func (stats *Stats) Print(batchmode bool, layout *DisplayWidgets) {
 	}
 	for i := 0; i < n; i++ {
 		mem = append(mem, fmt.Sprintf("%8s %10s %10s %8s",
-			misc.ByteSize(procsByMemUsage[i].MemUsage()),
+			misc.ByteSize(memUsage(procsByMemUsage[i])),
 			truncate(procsByMemUsage[i].Comm(), 10),
 			truncate(procsByMemUsage[i].User(), 10),
 			procsByMemUsage[i].Pid()))
@@ -128,9 +329,22 @@ func (stats *Stats) Print(batchmode bool, layout *DisplayWidgets) {
 		mem = append(mem, fmt.Sprintf("%8s %10s %10s %8s", "-", "-", "-", "-"))
 	}
 	displayList(batchmode, "memory", layout, mem)
-	printOsSpecific(batchmode, layout, stats.OsSpecific)
-	// finally deal with problems
-	displayList(batchmode, "problem", layout, stats.Problems)
+	if !batchmode {
+		layout.ProcessesByCPU.Border.Label = "CPU(c)"
+		layout.ProcessesByMemory.Border.Label = memLabel
+	}
+}
+
+func (stats *Stats) selectedRow() int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.selected
+}
+
+func (stats *Stats) setSelectedPid(pid string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.selectedPid = pid
 }
 
 // few small helper functions
@@ -155,22 +369,46 @@ func displayLine(batchmode bool, name string, layout *DisplayWidgets, line strin
 func displayList(batchmode bool, name string, layout *DisplayWidgets, list []string) {
 	if !batchmode {
 		switch name {
-		case "cpu":
+		case "cpu", "cpu(group)":
 			layout.ProcessesByCPU.Items = list
+		case "cpu(detail)":
+			layout.CPUDetail.Items = list
 		case "cpu(cgroup)":
 			layout.CgroupsCPU.Items = list
-		case "memory":
+		case "memory", "memory(group)":
 			layout.ProcessesByMemory.Items = list
 		case "memory(cgroup)":
 			layout.CgroupsMem.Items = list
-		case "io":
+		case "memory(numa)":
+			layout.MemoryNodes.Items = list
+		case "memory(slab)":
+			layout.SlabCaches.Items = list
+		case "io", "io(group)":
 			layout.ProcessesByIO.Items = list
+		case "runqueue":
+			layout.ProcessesByRunq.Items = list
+		case "states":
+			layout.ProcessStates.Items = list
+		case "churn":
+			layout.ProcessChurn.Items = list
+		case "threads":
+			layout.ProcessThreads.Items = list
+		case "tcp":
+			layout.TCPSockets.Items = list
 		case "interface":
 			layout.InterfaceUsage.Items = list
+		case "interface(detail)":
+			layout.InterfaceDetail.Items = list
+		case "interrupts":
+			layout.Interrupts.Items = list
 		case "filesystem":
 			layout.FileSystemUsage.Items = list
+		case "filesystem(detail)":
+			layout.FileSystemDetail.Items = list
 		case "diskio":
 			layout.DiskIOUsage.Items = list
+		case "diskio(detail)":
+			layout.DiskIODetail.Items = list
 		case "problem":
 			layout.Problems.Items = list
 		}
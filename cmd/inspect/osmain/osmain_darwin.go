@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/pidstat"
 )
 
 type darwinStats struct {
@@ -18,6 +19,36 @@ func registerOsSpecific(m *metrics.MetricContext, step time.Duration,
 	return x
 }
 
+// osSpecificPidFilter reports if a process should be tracked for
+// OS dependent reasons
+func osSpecificPidFilter(p *pidstat.PerProcessStat) bool {
+	return false
+}
+
+// processesByPSS is not supported on darwin
+func processesByPSS(stats *Stats) ([]*pidstat.PerProcessStat,
+	func(*pidstat.PerProcessStat) float64, bool) {
+	return nil, nil, false
+}
+
+// printProcessGroups is not supported on darwin
+func printProcessGroups(batchmode bool, layout *DisplayWidgets, stats *Stats) bool {
+	return false
+}
+
+// memorySummaryOsSpecific has nothing to add on darwin
+func memorySummaryOsSpecific(v interface{}) string {
+	return ""
+}
+
+// eventsOsSpecific returns no kernel events on darwin
+func eventsOsSpecific(v interface{}) interface{} {
+	return map[string]interface{}{
+		"counts": map[string]uint64{},
+		"events": []interface{}{},
+	}
+}
+
 // PrintOsSpecific prints OS dependent statistics
 func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 }
@@ -6,20 +6,31 @@ package osmain
 import (
 	"fmt"
 	"log"
+	"math"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/square/inspect/metrics"
 	"github.com/square/inspect/os/cpustat"
 	"github.com/square/inspect/os/diskstat"
 	"github.com/square/inspect/os/entropystat"
+	"github.com/square/inspect/os/fdstat"
 	"github.com/square/inspect/os/fsstat"
 	"github.com/square/inspect/os/interfacestat"
+	"github.com/square/inspect/os/irqstat"
+	"github.com/square/inspect/os/kmsg"
 	"github.com/square/inspect/os/loadstat"
+	"github.com/square/inspect/os/mdstat"
 	"github.com/square/inspect/os/memstat"
 	"github.com/square/inspect/os/misc"
 	"github.com/square/inspect/os/netstat"
+	"github.com/square/inspect/os/numastat"
+	"github.com/square/inspect/os/pidstat"
+	"github.com/square/inspect/os/slabstat"
+	"github.com/square/inspect/os/softnetstat"
 	"github.com/square/inspect/os/uptimestat"
 )
 
@@ -27,13 +38,26 @@ type linuxStats struct {
 	osind       *Stats
 	dstat       *diskstat.DiskStat
 	fsstat      *fsstat.FSStat
+	mdstat      *mdstat.MDStat
+	numastat    *numastat.NUMAStat
+	slabstat    *slabstat.SlabStat
+	kmsg        *kmsg.KmsgStat
 	ifstat      *interfacestat.InterfaceStat
 	netstat     *netstat.NetStat
+	socktable   *netstat.SocketTable
+	softnet     *softnetstat.SoftnetStat
+	irqstat     *irqstat.IRQStat
 	cgMem       *memstat.CgroupStat
 	cgCPU       *cpustat.CgroupStat
 	loadstat    *loadstat.LoadStat
 	uptimestat  *uptimestat.UptimeStat
 	entropystat *entropystat.EntropyStat
+	fdstat      *fdstat.FDStat
+	census      *pidstat.ProcessCensus
+	churn       *pidstat.ProcessChurn
+	threads     *pidstat.ThreadStat
+	threadSel   pidstat.ThreadSelector
+	watch       *pidstat.WatchList
 }
 
 // RegisterOsSpecific registers OS dependent statistics
@@ -42,17 +66,209 @@ func registerOsSpecific(m *metrics.MetricContext, step time.Duration,
 	s := new(linuxStats)
 	s.osind = osind
 	s.dstat = diskstat.New(m, step)
+	s.dstat.SetIncludePartitions(DiskPartitions)
+	s.dstat.SetIncludeDeviceMapper(DiskDeviceMapper)
 	s.fsstat = fsstat.New(m, step)
+	s.fsstat.SetFilter(fsFilter())
+	s.mdstat = mdstat.New(m, step)
+	s.numastat = numastat.New(m, step)
+	s.slabstat = slabstat.New(m, step)
+	s.kmsg = kmsg.New(m)
+	if KernelLogRules != "" {
+		rules, err := kmsg.ReadRules(KernelLogRules)
+		if err != nil {
+			log.Fatalf("Unable to read kernel log rules: %v", err)
+		}
+		s.kmsg.SetRules(rules)
+	}
 	s.ifstat = interfacestat.New(m, step)
 	s.netstat = netstat.New(m, step)
+	s.socktable = netstat.NewSocketTable(m, step)
+	s.softnet = softnetstat.New(m, step)
+	s.irqstat = irqstat.New(m, step)
 	s.loadstat = loadstat.New(m, step)
 	s.uptimestat = uptimestat.New(m, step)
 	s.cgMem = memstat.NewCgroupStat(m, step)
 	s.cgCPU = cpustat.NewCgroupStat(m, step)
 	s.entropystat = entropystat.New(m, step)
+	s.fdstat = fdstat.New(m, step)
+	s.census = pidstat.NewProcessCensus(m, osind.ProcessStat, step)
+	s.churn = pidstat.NewProcessChurn(m, osind.ProcessStat, step)
+	s.threads = pidstat.NewThreadStat(m, osind.ProcessStat, step)
+	if ThreadProcesses != "" {
+		sel, err := pidstat.ParseThreadSelector(ThreadProcesses)
+		if err != nil {
+			log.Fatalf("Unable to parse thread selection: %v", err)
+		}
+		s.threadSel = sel
+		s.threads.SetSelector(sel)
+	}
+	if WatchRules != "" {
+		rules, err := pidstat.ReadWatchRules(WatchRules)
+		if err != nil {
+			log.Fatalf("Unable to read process watch rules: %v", err)
+		}
+		s.watch = pidstat.NewWatchList(m, osind.ProcessStat, rules, step)
+	}
+	osind.ProcessStat.SetSmapsInterval(SmapsInterval)
 	return s
 }
 
+// irqDistribution returns share of an interrupt handled by the busiest
+// CPUs, say "cpu0:92% cpu3:8%"
+func irqDistribution(rates []float64, total float64) string {
+	cpus := make([]int, len(rates))
+	for i := range cpus {
+		cpus[i] = i
+	}
+	sort.SliceStable(cpus, func(i, j int) bool { return rates[cpus[i]] > rates[cpus[j]] })
+	var v []string
+	for _, cpu := range cpus {
+		if len(v) == 4 || rates[cpu] == 0 {
+			break
+		}
+		v = append(v, fmt.Sprintf("cpu%d:%.0f%%", cpu, rates[cpu]/total*100))
+	}
+	return strings.Join(v, " ")
+}
+
+// memorySummaryOsSpecific returns free memory of NUMA nodes if
+// there are more than one, say " (free node0: 62.5% node1: 1.6%)"
+func memorySummaryOsSpecific(v interface{}) string {
+	stats, ok := v.(*linuxStats)
+	if !ok || len(stats.numastat.Nodes) < 2 {
+		return ""
+	}
+	var nodes []string
+	for _, node := range stats.numastat.ByFree() {
+		nodes = append(nodes, fmt.Sprintf("%s: %3.1f%%", node.Name, node.FreePct()))
+	}
+	return " (free " + strings.Join(nodes, " ") + ")"
+}
+
+// signedByteSize returns human readable size with a sign, say -1.50MB
+func signedByteSize(v float64) string {
+	if v < 0 {
+		return "-" + misc.ByteSize(-v).String()
+	}
+	return "+" + misc.ByteSize(v).String()
+}
+
+// eventsOsSpecific returns kernel messages matching rules
+func eventsOsSpecific(v interface{}) interface{} {
+	stats, ok := v.(*linuxStats)
+	if !ok {
+		return nil
+	}
+	return stats.kmsg
+}
+
+// fsFilter returns default filter for filesystems with lists
+// overridden by users
+func fsFilter() fsstat.Filter {
+	f := fsstat.DefaultFilter
+	if FSIncludeTypes != nil {
+		f.IncludeTypes = FSIncludeTypes
+	}
+	if FSExcludeTypes != nil {
+		f.ExcludeTypes = FSExcludeTypes
+	}
+	if FSIncludeMountpoints != nil {
+		f.IncludeMountpoints = FSIncludeMountpoints
+	}
+	if FSExcludeMountpoints != nil {
+		f.ExcludeMountpoints = FSExcludeMountpoints
+	}
+	return f
+}
+
+// untilFull formats seconds until a filesystem is full
+func untilFull(seconds float64) string {
+	if math.IsNaN(seconds) || math.IsInf(seconds, 1) {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// groupFuncs maps names in ProcessGroupings to functions used
+// to aggregate processes
+var groupFuncs = map[string]pidstat.GroupFunc{
+	"comm":      pidstat.GroupByComm,
+	"user":      pidstat.GroupByUser,
+	"parent":    pidstat.GroupByParent,
+	"session":   pidstat.GroupBySession,
+	"cgroup":    pidstat.GroupByCgroup("cpu"),
+	"container": pidstat.GroupByContainer,
+}
+
+// printProcessGroups prints top groups of processes by cpu, memory
+// and io usage if processes are to be aggregated
+func printProcessGroups(batchmode bool, layout *DisplayWidgets, stats *Stats) bool {
+	groupBy := stats.GroupBy()
+	group, ok := groupFuncs[groupBy]
+	if !ok {
+		return false
+	}
+	var cpu, mem, io []string
+	all := stats.ProcessStat.Groups(group)
+	groups := pidstat.GroupsByCPUUsage(all)
+	for i := 0; i < MaxEntries; i++ {
+		if i < len(groups) {
+			g := groups[i]
+			cpuUsagePct := (g.CPUUsage() / stats.CPUStat.Total()) * 100
+			cpu = append(cpu, fmt.Sprintf("%5s %5d %30s",
+				fmt.Sprintf("%3.1f%%", cpuUsagePct), g.Count(), truncate(g.Name, 30)))
+		} else {
+			cpu = append(cpu, fmt.Sprintf("%5s %5s %30s", "-", "-", "-"))
+		}
+	}
+	groups = pidstat.GroupsByMemUsage(all)
+	for i := 0; i < MaxEntries; i++ {
+		if i < len(groups) {
+			g := groups[i]
+			mem = append(mem, fmt.Sprintf("%8s %5d %30s",
+				misc.ByteSize(g.MemUsage()), g.Count(), truncate(g.Name, 30)))
+		} else {
+			mem = append(mem, fmt.Sprintf("%8s %5s %30s", "-", "-", "-"))
+		}
+	}
+	groups = pidstat.GroupsByIOUsage(all)
+	for i := 0; i < MaxEntries; i++ {
+		if i < len(groups) {
+			g := groups[i]
+			io = append(io, fmt.Sprintf("%8s/s %5d %30s",
+				misc.ByteSize(g.IOUsage()), g.Count(), truncate(g.Name, 30)))
+		} else {
+			io = append(io, fmt.Sprintf("%8s/s %5s %30s", "-", "-", "-"))
+		}
+	}
+	displayList(batchmode, "cpu(group)", layout, cpu)
+	displayList(batchmode, "memory(group)", layout, mem)
+	displayList(batchmode, "io(group)", layout, io)
+	if !batchmode {
+		layout.ProcessesByCPU.Border.Label = "CPU(" + groupBy + ")(c)"
+		layout.ProcessesByMemory.Border.Label = "Memory(" + groupBy + ")(m)"
+		layout.ProcessesByIO.Border.Label = "IO(" + groupBy + ")(i)"
+	}
+	return true
+}
+
+// processesByPSS returns processes sorted by proportional set size
+// and a function to retrieve it, if smaps collection is enabled
+func processesByPSS(stats *Stats) ([]*pidstat.PerProcessStat,
+	func(*pidstat.PerProcessStat) float64, bool) {
+	if SmapsInterval == 0 {
+		return nil, nil, false
+	}
+	return stats.ProcessStat.ByPSS(), (*pidstat.PerProcessStat).PSSUsage, true
+}
+
+// osSpecificPidFilter reports processes which are close to their
+// resource limits so they are tracked regardless of usage
+func osSpecificPidFilter(p *pidstat.PerProcessStat) bool {
+	return p.FDUsage() > LimitUsagePct || p.MemlockUsage() > LimitUsagePct
+}
+
 // PrintOsSpecific prints OS dependent statistics
 func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 	stats, ok := v.(*linuxStats)
@@ -60,23 +276,204 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		log.Fatalf("Type assertion failed on printOsSpecific")
 	}
 	// Top N processes sorted by IO usage - requires root
-	procsByUsage := stats.osind.ProcessStat.ByIOUsage()
+	// groups of processes are printed by printProcessGroups
+	if _, ok := groupFuncs[stats.osind.GroupBy()]; !ok {
+		procsByUsage := stats.osind.ProcessStat.ByIOUsage()
+		n := MaxEntries
+		if len(procsByUsage) < n {
+			n = len(procsByUsage)
+		}
+		var io []string
+		for i := 0; i < n; i++ {
+			io = append(io, fmt.Sprintf("%8s/s %10s %10s %8s",
+				misc.ByteSize(procsByUsage[i].IOUsage()),
+				truncate(procsByUsage[i].Comm(), 10),
+				truncate(procsByUsage[i].User(), 10),
+				procsByUsage[i].Pid()))
+		}
+		for i := n; i < MaxEntries; i++ {
+			io = append(io, fmt.Sprintf("%8s/s %10s %10s %8s", "-", "-", "-", "-"))
+		}
+		displayList(batchmode, "io", layout, io)
+		if !batchmode {
+			layout.ProcessesByIO.Border.Label = "IO(i)"
+		}
+	}
+	// Top N processes sorted by time spent waiting for a CPU
+	procsByUsage := stats.osind.ProcessStat.ByRunqueueLatency()
 	n := MaxEntries
 	if len(procsByUsage) < n {
 		n = len(procsByUsage)
 	}
-	var io []string
+	var runq []string
 	for i := 0; i < n; i++ {
-		io = append(io, fmt.Sprintf("%8s/s %10s %10s %8s",
-			misc.ByteSize(procsByUsage[i].IOUsage()),
+		runq = append(runq, fmt.Sprintf("%8s %8s %4s %10s %10s %8s",
+			fmt.Sprintf("%3.1fms", procsByUsage[i].RunqueueLatency()*1000),
+			fmt.Sprintf("%.0f/s", procsByUsage[i].ContextSwitches()),
+			fmt.Sprintf("%.0f", procsByUsage[i].Threads()),
 			truncate(procsByUsage[i].Comm(), 10),
 			truncate(procsByUsage[i].User(), 10),
 			procsByUsage[i].Pid()))
 	}
 	for i := n; i < MaxEntries; i++ {
-		io = append(io, fmt.Sprintf("%8s/s %10s %10s %8s", "-", "-", "-", "-"))
+		runq = append(runq, fmt.Sprintf("%8s %8s %4s %10s %10s %8s", "-", "-", "-", "-", "-", "-"))
+	}
+	displayList(batchmode, "runqueue", layout, runq)
+	// processes and threads by state, blocked tasks
+	census := stats.census.Metrics
+	states := []string{fmt.Sprintf(
+		"procs: %.0f R:%.0f S:%.0f D:%.0f Z:%.0f T:%.0f threads: %.0f R:%.0f D:%.0f forks: %.1f/s",
+		census.Processes.Get(), census.Running.Get(), census.Sleeping.Get(),
+		census.DiskSleep.Get(), census.Zombie.Get(), census.Stopped.Get(),
+		census.Threads.Get(), census.ThreadsRunning.Get(),
+		census.ThreadsDiskSleep.Get(), stats.census.ForkRate())}
+	for _, task := range stats.census.Blocked {
+		wchan := task.Wchan
+		if wchan == "" {
+			wchan = "-"
+		}
+		stack := task.Stack
+		if len(stack) > 4 {
+			stack = stack[:4]
+		}
+		states = append(states, fmt.Sprintf("%8s %8s %-16s D %-24s %s",
+			task.Pid, task.Tid, truncate(task.Comm, 16), truncate(wchan, 24),
+			strings.Join(stack, " < ")))
+	}
+	if blocked := stats.census.SustainedBlocked(); blocked > BlockedTasks {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("%.0f threads stuck in uninterruptible sleep (D)", blocked))
+	}
+	// zombies pile up when a parent does not reap its children
+	if growth := stats.census.ZombieGrowth(); growth > 0 {
+		ppid, comm, n := stats.census.TopZombieParent()
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("Zombie processes growing: %.0f (+%.0f), most from %s(%s) with %d",
+				census.Zombie.Get(), growth, comm, ppid, n))
+	}
+	displayList(batchmode, "states", layout, states)
+	// short-lived processes missed by the per-process lists
+	source := "scan"
+	if stats.churn.UsesConnector() {
+		source = "netlink"
+	}
+	churn := []string{fmt.Sprintf("starts: %.1f/s exits: %.1f/s over %v (%s)",
+		stats.churn.StartRate(), stats.churn.ExitRate(), pidstat.DefaultChurnWindow, source)}
+	for i, o := range stats.churn.ByParent() {
+		if i == MaxEntries {
+			break
+		}
+		churn = append(churn, fmt.Sprintf("parent  %-24s cpu of exited children: %5.1f%%",
+			truncate(o.Name, 24), o.CPU*100))
+	}
+	for i, o := range stats.churn.ByCommand() {
+		if i == MaxEntries {
+			break
+		}
+		churn = append(churn, fmt.Sprintf("command %-24s starts: %6.1f/s exits: %6.1f/s cpu: %5.1f%%",
+			truncate(o.Name, 24), o.Starts, o.Exits, o.CPU*100))
+	}
+	displayList(batchmode, "churn", layout, churn)
+	// threads of selected processes, say a JVM using many CPUs
+	sel := stats.threadSel
+	if pid := stats.osind.ThreadsOf(); pid != "" {
+		sel.Pids = append(append([]string(nil), sel.Pids...), pid)
+	}
+	stats.threads.SetSelector(sel)
+	var threads []string
+	for i, o := range stats.threads.ThreadsByCPUUsage() {
+		if i == MaxEntries {
+			break
+		}
+		threads = append(threads, fmt.Sprintf("%5s %8s %8s %-16s %s",
+			fmt.Sprintf("%3.1f%%", o.CPUUsage()/stats.osind.CPUStat.Total()*100),
+			o.Pid(), o.Tid(), truncate(o.Comm(), 16), o.State()))
+	}
+	displayList(batchmode, "threads", layout, threads)
+	// processes expected to be running and within their limits
+	if stats.watch != nil {
+		var watch []string
+		for _, o := range stats.watch.ByRule() {
+			status := "ok"
+			if len(o.Violations) > 0 {
+				status = strings.Join(o.Violations, "; ")
+			}
+			watch = append(watch, fmt.Sprintf("%-16s count: %3.0f cpu: %5.1f%% rss: %8s %s",
+				truncate(o.Rule.Name, 16), o.Metrics.Count.Get(), o.Metrics.CPU.Get()*100,
+				misc.ByteSize(o.Metrics.RSS.Get()), status))
+		}
+		displayList(batchmode, "watch", layout, watch)
+		for _, o := range stats.watch.Violations() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Watched processes %s: %s", o.Rule.Name,
+					strings.Join(o.Violations, "; ")))
+		}
+	}
+	// Detect processes close to their resource limits
+	for _, p := range stats.osind.ProcessStat.ByFDUsage() {
+		if p.FDUsage() > LimitUsagePct {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Open files for %s(%s): %.0f of %.0f (%3.1f%%)",
+					p.Comm(), p.Pid(), p.OpenFiles(), p.Metrics.NofileSoft.Get(),
+					p.FDUsage()))
+		}
 	}
-	displayList(batchmode, "io", layout, io)
+	for _, p := range stats.osind.ProcessStat.ByMemUsage() {
+		if p.MemlockUsage() > LimitUsagePct {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Locked memory for %s(%s): %3.1f%% of limit",
+					p.Comm(), p.Pid(), p.MemlockUsage()))
+		}
+	}
+	if stats.fdstat.Usage() > LimitUsagePct {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("System wide file handle usage: %3.1f%%",
+				stats.fdstat.Usage()))
+	}
+	// TCP sockets by state, listeners by accept queue usage and
+	// processes holding sockets in CLOSE_WAIT
+	st := &stats.socktable.States
+	tcp := []string{fmt.Sprintf(
+		"estab: %.0f syn_recv: %.0f time_wait: %.0f close_wait: %.0f fin_wait: %.0f listen: %.0f",
+		st.Established.Get(), st.SynRecv.Get(), st.TimeWait.Get(),
+		st.CloseWait.Get(), st.FinWait1.Get()+st.FinWait2.Get(), st.Listen.Get())}
+	for _, l := range stats.socktable.ListenersByQueueUsage() {
+		tcp = append(tcp, fmt.Sprintf("%30s %6s %12s %10s %8s",
+			truncate(l.Name, 30), fmt.Sprintf("%3.1f%%", l.QueueUsage()),
+			fmt.Sprintf("%.0f/%.0f", l.AcceptQueue.Get(), l.Backlog.Get()),
+			truncate(l.Comm, 10), l.Pid))
+		if l.QueueUsage() > AcceptQueuePct {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Accept queue on %s (%s): %.0f of %.0f",
+					l.Name, l.Comm, l.AcceptQueue.Get(), l.Backlog.Get()))
+		}
+	}
+	for _, o := range stats.socktable.CloseWaitByProcess() {
+		tcp = append(tcp, fmt.Sprintf("%30s %6d %12s %10s %8s",
+			"close_wait", o.Count, "-", truncate(o.Comm, 10), o.Pid))
+		if o.Count > CloseWaitThreshold {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Sockets in CLOSE_WAIT held by %s(%s): %d",
+					o.Comm, o.Pid, o.Count))
+		}
+	}
+	sock := &stats.netstat.SockStat
+	tcp = append(tcp, fmt.Sprintf(
+		"orphans: %.0f of %.0f tcp mem: %s (%3.1f%% of pressure, %3.1f%% of max)",
+		sock.TCPOrphan.Get(), sock.TCPMaxOrphans.Get(),
+		misc.ByteSize(sock.TCPMem.Get()*float64(os.Getpagesize())),
+		sock.TCPMemPressureUsage(), sock.TCPMemUsage()))
+	if sock.OrphanUsage() > LimitUsagePct {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("Orphaned TCP sockets: %.0f of %.0f (%3.1f%%)",
+				sock.TCPOrphan.Get(), sock.TCPMaxOrphans.Get(), sock.OrphanUsage()))
+	}
+	if sock.TCPMemPressureUsage() > LimitUsagePct {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("TCP memory: %3.1f%% of pressure threshold",
+				sock.TCPMemPressureUsage()))
+	}
+	displayList(batchmode, "tcp", layout, tcp)
 	// Print top-N diskIO usage
 	// disk stats
 	diskIOByUsage := stats.dstat.ByUsage()
@@ -87,12 +484,44 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		diskIO := 0.0
 		if len(diskIOByUsage) > i {
 			d := diskIOByUsage[i]
-			diskName = d.Name
+			diskName = d.DisplayName()
 			diskIO = d.Usage()
 		}
 		diskio = append(diskio, fmt.Sprintf("%6s %5s", diskName, fmt.Sprintf("%3.1f%% ", diskIO)))
 	}
 	displayList(batchmode, "diskio", layout, diskio)
+	// iostat like statistics for all disks
+	var diskdetail []string
+	for _, d := range diskIOByUsage {
+		diskdetail = append(diskdetail, fmt.Sprintf(
+			"%8s r/s:%7.1f w/s:%7.1f rkB/s:%9.1f wkB/s:%9.1f r_await:%6.1f w_await:%6.1f qu:%5.1f rqkB:%6.1f %%util:%5.1f",
+			truncate(d.DisplayName(), 8), d.ReadIOPS.Get(), d.WriteIOPS.Get(),
+			d.ReadKBps.Get(), d.WriteKBps.Get(), d.ReadAwait.Get(),
+			d.WriteAwait.Get(), d.AvgQueueSize.Get(), d.AvgRequestSize.Get(),
+			d.Usage()))
+	}
+	// software RAID arrays
+	for _, md := range stats.mdstat.ByName() {
+		sync := md.SyncAction
+		if md.IsSyncing() {
+			sync = fmt.Sprintf("%s %3.1f%% %.0fK/s", md.SyncAction,
+				md.Metrics.SyncCompleted.Get(), md.Metrics.SyncSpeed.Get())
+		}
+		diskdetail = append(diskdetail, fmt.Sprintf("%8s %7s %8s disks:%.0f/%.0f %s",
+			md.Name, md.Level, md.State, md.Metrics.ActiveDisks.Get(),
+			md.Metrics.RaidDisks.Get(), sync))
+		if md.IsDegraded() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("RAID array (%v) degraded: %.0f device(s) missing, failed: %s",
+					md.Name, md.Metrics.Degraded.Get(), strings.Join(md.FailedDevices, ",")))
+		}
+		if md.IsStalled() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("RAID array (%v) %s stalled at %3.1f%%",
+					md.Name, md.SyncAction, md.Metrics.SyncCompleted.Get()))
+		}
+	}
+	displayList(batchmode, "diskio(detail)", layout, diskdetail)
 	// Print top-N File system  usage
 	// disk stats
 	fsByUsage := stats.fsstat.ByUsage()
@@ -113,11 +542,49 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 			fmt.Sprintf("%3.1f%%", fsInodes)))
 	}
 	displayList(batchmode, "filesystem", layout, fs)
+	// disk IO of devices backing each filesystem
+	var fsdetail []string
+	for _, f := range fsByUsage {
+		var reads, writes, await float64
+		var disks []string
+		for _, d := range stats.dstat.ByDevNum(f.DevNum) {
+			reads += d.ReadIOPS.Get()
+			writes += d.WriteIOPS.Get()
+			if d.Await() > await {
+				await = d.Await()
+			}
+			disks = append(disks, d.DisplayName())
+		}
+		device := "-"
+		if len(disks) > 0 {
+			device = strings.Join(disks, ",")
+		}
+		fsdetail = append(fsdetail, fmt.Sprintf(
+			"%20s %6s i:%6s full in:%8s %12s r/s:%7.1f w/s:%7.1f await:%6.1f",
+			truncate(f.Name, 20), fmt.Sprintf("%3.1f%%", f.Usage()),
+			fmt.Sprintf("%3.1f%%", f.FileUsage()),
+			untilFull(f.SecondsUntilFull.Get()), truncate(device, 12),
+			reads, writes, await))
+	}
+	displayList(batchmode, "filesystem(detail)", layout, fsdetail)
 	// Detect potential problems for disk/fs
 	for _, d := range diskIOByUsage {
-		if d.Usage() > 75.0 {
+		// devices serving requests in parallel (say NVMe) can be
+		// 100% busy with plenty of capacity left; rely on latency
+		// and queue depth for those
+		if d.Usage() > 75.0 && stats.dstat.IsRotational(d) {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Disk IO usage on (%v): %3.1f%%", d.DisplayName(), d.Usage()))
+		}
+		if d.Await() > DiskAwaitMsecs {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Disk IO latency on (%v): r_await %3.1fms w_await %3.1fms",
+					d.DisplayName(), d.ReadAwait.Get(), d.WriteAwait.Get()))
+		}
+		if d.AvgQueueSize.Get() > DiskQueueSize {
 			stats.osind.Problems = append(stats.osind.Problems,
-				fmt.Sprintf("Disk IO usage on (%v): %3.1f%%", d.Name, d.Usage()))
+				fmt.Sprintf("Disk IO queue on (%v): %3.1f requests",
+					d.DisplayName(), d.AvgQueueSize.Get()))
 		}
 	}
 	for _, fs := range fsByUsage {
@@ -129,6 +596,19 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 			stats.osind.Problems = append(stats.osind.Problems,
 				fmt.Sprintf("FS inode usage on (%v): %3.1f%%", fs.Name, fs.FileUsage()))
 		}
+		if full := fs.SecondsUntilFull.Get(); full < FSFullHorizon.Seconds() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("FS (%v) forecast to be full in %s", fs.Name, untilFull(full)))
+		}
+		if full := fs.SecondsUntilInodesFull.Get(); full < FSFullHorizon.Seconds() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("FS (%v) forecast to run out of inodes in %s",
+					fs.Name, untilFull(full)))
+		}
+		if fs.RemountedReadOnly {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("FS (%v) on %s remounted read-only", fs.Name, fs.Device))
+		}
 	}
 	// Interface usage statistics
 	var interfaces []string
@@ -158,6 +638,132 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		}
 	}
 	displayList(batchmode, "interface", layout, interfaces)
+	// Packets, errors and drops per interface and packets dropped
+	// by CPUs processing network softirqs
+	var ifdetail []string
+	for _, iface := range interfaceByUsage {
+		link := "-"
+		if iface.Metrics.Speed.Get() > 0 {
+			link = fmt.Sprintf("%.0fMb/%s", iface.Metrics.Speed.Get(), iface.Duplex)
+		}
+		ifdetail = append(ifdetail, fmt.Sprintf(
+			"%10s r:%8s %8s err:%6s drop:%6s t:%8s %8s err:%6s drop:%6s %s %s mtu:%.0f",
+			truncate(iface.Name, 10),
+			misc.BitSize(iface.RXBandwidth()),
+			fmt.Sprintf("%.0fp/s", iface.RXPackets()),
+			fmt.Sprintf("%.0f/s", iface.RXErrors()),
+			fmt.Sprintf("%.0f/s", iface.RXDrops()),
+			misc.BitSize(iface.TXBandwidth()),
+			fmt.Sprintf("%.0fp/s", iface.TXPackets()),
+			fmt.Sprintf("%.0f/s", iface.TXErrors()),
+			fmt.Sprintf("%.0f/s", iface.TXDrops()),
+			iface.OperState, link, iface.Metrics.MTU.Get()))
+		if iface.CarrierFlaps() > 0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Link flapping on (%v): %d carrier changes",
+					iface.Name, iface.Metrics.CarrierChanges.Get()))
+		}
+		if iface.HalfDuplex() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Link on (%v) negotiated half duplex at %.0fMb/s",
+					iface.Name, iface.Metrics.Speed.Get()))
+		}
+		// a small fraction of bad packets is worth looking into
+		if iface.RXDropUsage() > 1.0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("RX drops/errors on (%v): %3.1f%% of packets",
+					iface.Name, iface.RXDropUsage()))
+		}
+		if iface.TXDropUsage() > 1.0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("TX drops/errors on (%v): %3.1f%% of packets",
+					iface.Name, iface.TXDropUsage()))
+		}
+	}
+	for _, bond := range stats.ifstat.Bonds {
+		ifdetail = append(ifdetail, fmt.Sprintf("%10s %s slaves: %.0f/%.0f up (%s)",
+			truncate(bond.Name, 10), bond.MIIStatus, bond.Metrics.SlavesUp.Get(),
+			bond.Metrics.Slaves.Get(), bond.Mode))
+		if bond.Degraded() {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Bond (%v) degraded: %s with %d slave(s) down or missing",
+					bond.Name, bond.MIIStatus, bond.SlavesDown()))
+		}
+		if bond.LinkFailures() > 0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Link failures on slaves of bond (%v)", bond.Name))
+		}
+	}
+	for _, cpu := range stats.softnet.ByDropped() {
+		dropped := cpu.Dropped.ComputeRate()
+		squeezed := cpu.TimeSqueeze.ComputeRate()
+		if dropped == 0 && squeezed == 0 {
+			continue
+		}
+		ifdetail = append(ifdetail, fmt.Sprintf("%10s softnet drop:%6s squeeze:%6s",
+			cpu.Name, fmt.Sprintf("%.0f/s", dropped), fmt.Sprintf("%.0f/s", squeezed)))
+		if dropped > 0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Softnet backlog drops on %s: %.0f/s", cpu.Name, dropped))
+		}
+		// occasional squeezes are normal under load
+		if squeezed > 10 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Softnet time squeezes on %s: %.0f/s", cpu.Name, squeezed))
+		}
+	}
+	displayList(batchmode, "interface(detail)", layout, ifdetail)
+	// time breakdown by CPU
+	var cpudetail []string
+	for _, cpu := range stats.osind.CPUStat.PerCPUStats() {
+		cpudetail = append(cpudetail, fmt.Sprintf(
+			"%6s usr:%5.1f%% sys:%5.1f%% irq:%5.1f%% soft:%5.1f%% iowait:%5.1f%% steal:%5.1f%%",
+			cpu.Name, cpu.UserSpace()*100, cpu.SystemUsage()*100, cpu.IrqUsage()*100,
+			cpu.SoftirqUsage()*100, cpu.IowaitUsage()*100, cpu.StealUsage()*100))
+	}
+	displayList(batchmode, "cpu(detail)", layout, cpudetail)
+	// interrupts by CPU and hot IRQs
+	var interrupts []string
+	for _, cpu := range stats.irqstat.CPUs {
+		interrupts = append(interrupts, fmt.Sprintf(
+			"%10s irq:%8s softirq:%8s net_rx:%8s net_tx:%8s block:%8s",
+			cpu.Name,
+			fmt.Sprintf("%.0f/s", cpu.Rate()),
+			fmt.Sprintf("%.0f/s", cpu.Softirqs.ComputeRate()),
+			fmt.Sprintf("%.0f/s", cpu.NetRX.ComputeRate()),
+			fmt.Sprintf("%.0f/s", cpu.NetTX.ComputeRate()),
+			fmt.Sprintf("%.0f/s", cpu.Block.ComputeRate())))
+	}
+	if score, cpu := stats.irqstat.Imbalance(); score > IRQImbalance &&
+		stats.irqstat.Rate() > IRQRate {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("Interrupts concentrated on cpu%d: imbalance %.2f at %.0f/s",
+				cpu, score, stats.irqstat.Rate()))
+	}
+	for _, name := range []string{"NET_RX", "NET_TX", "BLOCK"} {
+		softirq, ok := stats.irqstat.Softirqs[name]
+		if !ok || softirq.Rate() < IRQRate {
+			continue
+		}
+		if score, cpu := softirq.Imbalance(); score > IRQImbalance {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("%s softirqs concentrated on cpu%d: imbalance %.2f at %.0f/s",
+					name, cpu, score, softirq.Rate()))
+		}
+	}
+	for _, irq := range stats.irqstat.ByRate() {
+		if irq.Rate() == 0 {
+			break
+		}
+		// MSI-X queue interrupts are pinned to a single CPU by design,
+		// so imbalance of an individual IRQ is shown but not a problem
+		score, _ := irq.Imbalance()
+		interrupts = append(interrupts, fmt.Sprintf("%10s %-20s %8s imbalance:%4.2f %s",
+			"irq"+irq.Name, truncate(irq.Device, 20),
+			fmt.Sprintf("%.0f/s", irq.Rate()), score,
+			irqDistribution(irq.CPURates(), irq.Rate())))
+	}
+	displayList(batchmode, "interrupts", layout, interrupts)
 	// CPU stats by cgroup
 	// TODO(syamp): should be sorted by quota usage
 	var cgcpu, keys []string
@@ -169,6 +775,9 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		v, ok := stats.cgCPU.Cgroups[name]
 		if ok {
 			name, _ = filepath.Rel(stats.cgCPU.Mountpoint, name)
+			if v.Container != nil {
+				name = v.Container.String()
+			}
 			cpuUsagePct := (v.Usage() / stats.osind.CPUStat.Total()) * 100
 			cpuQuotaPct := (v.Usage() / v.Quota()) * 100
 			cpuThrottle := v.Throttle() * 100
@@ -199,6 +808,9 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		v, ok := stats.cgMem.Cgroups[name]
 		if ok {
 			name, _ = filepath.Rel(stats.cgMem.Mountpoint, name)
+			if v.Container != nil {
+				name = v.Container.String()
+			}
 			memUsagePct := (v.Usage() / stats.osind.MemStat.Total()) * 100
 			memQuota := v.SoftLimit()
 			if memQuota > stats.osind.MemStat.Total() {
@@ -219,6 +831,69 @@ func printOsSpecific(batchmode bool, layout *DisplayWidgets, v interface{}) {
 		}
 	}
 	displayList(batchmode, "memory(cgroup)", layout, cgmem)
+	// memory by NUMA node
+	var numa []string
+	nodes := stats.numastat.ByFree()
+	for _, node := range nodes {
+		numa = append(numa, fmt.Sprintf(
+			"%6s total:%8s free:%8s file:%8s anon:%8s miss:%5.1f%% cpus:%s",
+			node.Name,
+			misc.ByteSize(node.Metrics.MemTotal.Get()),
+			misc.ByteSize(node.Metrics.MemFree.Get()),
+			misc.ByteSize(node.Metrics.FilePages.Get()),
+			misc.ByteSize(node.Metrics.AnonPages.Get()),
+			node.MissUsage(), node.CPUList))
+	}
+	// allocations bound to an exhausted node swap or reclaim even
+	// though other nodes have plenty free
+	if len(nodes) > 1 {
+		low, high := nodes[0], nodes[len(nodes)-1]
+		if low.FreePct() < 5.0 && high.FreePct() > 25.0 {
+			stats.osind.Problems = append(stats.osind.Problems,
+				fmt.Sprintf("Memory on NUMA %s nearly exhausted (%3.1f%% free) while %s has %3.1f%% free",
+					low.Name, low.FreePct(), high.Name, high.FreePct()))
+		}
+	}
+	displayList(batchmode, "memory(numa)", layout, numa)
+	// largest slab caches; /proc/slabinfo is readable only by root
+	var slab []string
+	caches := stats.slabstat.BySize()
+	if len(caches) > MaxEntries {
+		caches = caches[:MaxEntries]
+	}
+	for _, cache := range caches {
+		slab = append(slab, fmt.Sprintf("%20s %8s %10s objs:%8d/%-8d %6dB",
+			truncate(cache.Name, 20),
+			misc.ByteSize(cache.Size()),
+			signedByteSize(cache.Growth())+"/s",
+			cache.ActiveObjs, cache.NumObjs, cache.ObjSize))
+	}
+	// leaked kernel objects can not be reclaimed under memory pressure
+	growth := stats.slabstat.UnreclaimableGrowth()
+	if stats.slabstat.IsUnreclaimableGrowing() &&
+		growth*slabstat.DefaultWindow.Seconds() > stats.osind.MemStat.Total()*0.01 {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("Unreclaimable slab growing steadily: %s at %s/s",
+				misc.ByteSize(stats.slabstat.Unreclaim.Get()),
+				misc.ByteSize(growth)))
+	}
+	displayList(batchmode, "memory(slab)", layout, slab)
 	entropy := fmt.Sprintf("%10.0f", stats.entropystat.Available.Get())
+	// recent kernel messages pointing at hardware or kernel problems
+	var classes []string
+	latest := make(map[string]kmsg.Event)
+	count := make(map[string]int)
+	for _, e := range stats.kmsg.Recent(KernelEventWindow) {
+		if count[e.Class] == 0 {
+			classes = append(classes, e.Class)
+		}
+		count[e.Class]++
+		latest[e.Class] = e
+	}
+	for _, class := range classes {
+		stats.osind.Problems = append(stats.osind.Problems,
+			fmt.Sprintf("Kernel log: %s x%d in last %v, latest: %s",
+				class, count[class], KernelEventWindow, truncate(latest[class].Message, 80)))
+	}
 	displayList(batchmode, "entropy", layout, []string{entropy})
 }
@@ -14,20 +14,44 @@ func uiWidgets() *osmain.DisplayWidgets {
 	widgets.Summary = termui.NewPar("Gathering statistics ...")
 	widgets.ProcessesByCPU = termui.NewList()
 	widgets.ProcessesByCPU.Border.Label = "CPU(c)"
+	widgets.CPUDetail = termui.NewList()
+	widgets.CPUDetail.Border.Label = "CPU time by CPU(u)"
 	widgets.ProcessesByMemory = termui.NewList()
 	widgets.ProcessesByMemory.Border.Label = "Memory(m)"
 	widgets.ProcessesByIO = termui.NewList()
 	widgets.ProcessesByIO.Border.Label = "IO(i)"
+	widgets.ProcessesByRunq = termui.NewList()
+	widgets.ProcessesByRunq.Border.Label = "Run queue latency(r)"
+	widgets.ProcessStates = termui.NewList()
+	widgets.ProcessStates.Border.Label = "Process states and blocked tasks(D)"
+	widgets.ProcessChurn = termui.NewList()
+	widgets.ProcessChurn.Border.Label = "Process churn(x)"
+	widgets.ProcessThreads = termui.NewList()
+	widgets.ProcessThreads.Border.Label = "Threads by CPU(T)"
+	widgets.TCPSockets = termui.NewList()
+	widgets.TCPSockets.Border.Label = "TCP sockets(t)"
 	widgets.DiskIOUsage = termui.NewList()
 	widgets.DiskIOUsage.Border.Label = "Disk IO usage(d)"
+	widgets.DiskIODetail = termui.NewList()
+	widgets.DiskIODetail.Border.Label = "Disk IO(d)"
 	widgets.FileSystemUsage = termui.NewList()
 	widgets.FileSystemUsage.Border.Label = "Filesystem usage(f)"
+	widgets.FileSystemDetail = termui.NewList()
+	widgets.FileSystemDetail.Border.Label = "Filesystem usage and IO(f)"
 	widgets.InterfaceUsage = termui.NewList()
 	widgets.InterfaceUsage.Border.Label = "Network usage(n)"
+	widgets.InterfaceDetail = termui.NewList()
+	widgets.InterfaceDetail.Border.Label = "Network packets, errors and drops(n)"
+	widgets.Interrupts = termui.NewList()
+	widgets.Interrupts.Border.Label = "Interrupts by CPU(I)"
 	widgets.CgroupsCPU = termui.NewList()
 	widgets.CgroupsCPU.Border.Label = "CPU(cgroups)(C)"
 	widgets.CgroupsMem = termui.NewList()
 	widgets.CgroupsMem.Border.Label = "Memory(cgroups)(M)"
+	widgets.MemoryNodes = termui.NewList()
+	widgets.MemoryNodes.Border.Label = "Memory by NUMA node(N)"
+	widgets.SlabCaches = termui.NewList()
+	widgets.SlabCaches.Border.Label = "Kernel slab caches(S)"
 	widgets.Problems = termui.NewList()
 	widgets.Problems.Border.Label = "Problems(p)"
 	uiResetAttributes(widgets)
@@ -38,20 +62,44 @@ func uiResetAttributes(widgets *osmain.DisplayWidgets) {
 	widgets.Summary.Height = 3
 	widgets.ProcessesByCPU.Height = 5
 	widgets.ProcessesByCPU.Border.Label = "CPU(c)"
+	widgets.CPUDetail.Height = 5
+	widgets.CPUDetail.Border.Label = "CPU time by CPU(u)"
 	widgets.ProcessesByMemory.Height = 5
 	widgets.ProcessesByMemory.Border.Label = "Memory(m)"
 	widgets.ProcessesByIO.Height = 5
 	widgets.ProcessesByIO.Border.Label = "IO(i)"
+	widgets.ProcessesByRunq.Height = 5
+	widgets.ProcessesByRunq.Border.Label = "Run queue latency(r)"
+	widgets.ProcessStates.Height = 5
+	widgets.ProcessStates.Border.Label = "Process states and blocked tasks(D)"
+	widgets.ProcessChurn.Height = 5
+	widgets.ProcessChurn.Border.Label = "Process churn(x)"
+	widgets.ProcessThreads.Height = 5
+	widgets.ProcessThreads.Border.Label = "Threads by CPU(T)"
+	widgets.TCPSockets.Height = 5
+	widgets.TCPSockets.Border.Label = "TCP sockets(t)"
 	widgets.DiskIOUsage.Height = 5
 	widgets.DiskIOUsage.Border.Label = "Disk IO usage(d)"
+	widgets.DiskIODetail.Height = 5
+	widgets.DiskIODetail.Border.Label = "Disk IO(d)"
 	widgets.FileSystemUsage.Height = 5
 	widgets.FileSystemUsage.Border.Label = "Filesystem usage(f)"
+	widgets.FileSystemDetail.Height = 5
+	widgets.FileSystemDetail.Border.Label = "Filesystem usage and IO(f)"
 	widgets.InterfaceUsage.Height = 5
 	widgets.InterfaceUsage.Border.Label = "Network usage(n)"
+	widgets.InterfaceDetail.Height = 5
+	widgets.InterfaceDetail.Border.Label = "Network packets, errors and drops(n)"
+	widgets.Interrupts.Height = 5
+	widgets.Interrupts.Border.Label = "Interrupts by CPU(I)"
 	widgets.CgroupsCPU.Height = 10
 	widgets.CgroupsCPU.Border.Label = "CPU(cgroups)(C)"
 	widgets.CgroupsMem.Height = 10
 	widgets.CgroupsMem.Border.Label = "Memory(cgroups)(M)"
+	widgets.MemoryNodes.Height = 5
+	widgets.MemoryNodes.Border.Label = "Memory by NUMA node(N)"
+	widgets.SlabCaches.Height = 5
+	widgets.SlabCaches.Border.Label = "Kernel slab caches(S)"
 	widgets.Problems.Height = 10
 	widgets.Problems.Border.Label = "Problems(p)"
 }
@@ -87,13 +135,25 @@ func uiHelp() *termui.Grid {
 		"h: Help",
 		"s: Summary view",
 		"c: processes by cpu usage",
+		"j/k: select next/previous process in the cpu list",
+		"T: threads of the selected process and of -threads by cpu usage",
+		"u: cpu time by CPU: user, kernel, irq, softirq, iowait and steal",
 		"C: cgroups for cpu subsystem",
 		"m: processes by memory usage",
+		"P: toggle memory usage between RSS and PSS (requires -smaps)",
+		"g: cycle process lists between per-process and per-comm/user/parent/session/cgroup/container",
 		"M: cgroups for memory subsystem",
+		"N: memory and allocation misses by NUMA node",
+		"S: largest kernel slab caches and their growth (requires root)",
 		"i: processes by io",
-		"d: disk io statistics",
-		"f: filesystem statistics",
-		"n: network interface statistics",
+		"r: processes by run queue latency and context switches",
+		"D: processes and threads by state, fork rate and threads in uninterruptible sleep",
+		"x: short-lived processes: starts and exits by command, CPU of exited children by parent",
+		"t: tcp sockets by state, accept queues, CLOSE_WAIT owners and socket memory",
+		"d: disk io statistics: iops, throughput, latency, queue size and RAID arrays",
+		"f: filesystem statistics with IO of backing disks",
+		"n: network interface packets, errors, drops, link state, bonds and softnet drops",
+		"I: interrupts and softirqs by CPU and hot IRQs with their CPU distribution",
 		"p: problems found",
 		"q: Quit",
 	}
@@ -120,3 +180,17 @@ func uiRefresh() {
 	termui.Body.Align()
 	termui.Render(termui.Body)
 }
+
+// uiNextGrouping returns grouping of processes following the current
+// one. Per-process view (empty grouping) follows the last one.
+func uiNextGrouping(current string) string {
+	if current == "" {
+		return osmain.ProcessGroupings[0]
+	}
+	for i, g := range osmain.ProcessGroupings {
+		if g == current && i+1 < len(osmain.ProcessGroupings) {
+			return osmain.ProcessGroupings[i+1]
+		}
+	}
+	return ""
+}
@@ -15,9 +15,10 @@ import (
 
 // MetricJSON is a type for serializing any metric type
 type MetricJSON struct {
-	Type  string
-	Name  string
-	Value interface{}
+	Type     string
+	Name     string
+	Value    interface{}
+	Metadata map[string]string `json:",omitempty"`
 }
 
 // EncodeJSON is a streaming encoder that writes all metrics passing filter
@@ -66,5 +67,6 @@ func (m *MetricContext) marshalMetricJSON(name string, v interface{}) ([]byte, e
 	o.Type = reflect.TypeOf(v).String()
 	o.Name = name
 	o.Value = v
+	o.Metadata = m.metadata[name]
 	return json.Marshal(o)
 }
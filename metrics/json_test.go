@@ -25,3 +25,25 @@ func TestJsonHandler(t *testing.T) {
 			response.Body.String())
 	}
 }
+
+func TestJsonMetadata(t *testing.T) {
+	m := NewMetricContext("test")
+	g := NewGauge()
+	m.Register(g, "testGauge")
+	g.Set(float64(42))
+	m.SetMetadata("testGauge", map[string]string{"container": "web"})
+	req, err := http.NewRequest("GET", "metrics.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	m.HttpJsonHandler(response, req)
+	if !strings.Contains(response.Body.String(), `"Metadata":{"container":"web"}`) {
+		t.Errorf("Expected metadata in response, but got: " +
+			response.Body.String())
+	}
+	m.Unregister(g, "testGauge")
+	if m.Metadata("testGauge") != nil {
+		t.Errorf("Expected metadata to be removed on Unregister")
+	}
+}
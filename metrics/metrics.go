@@ -23,6 +23,7 @@ type MetricContext struct {
 	BasicCounters map[string]*BasicCounter
 	StatsTimers   map[string]*StatsTimer
 	OutputFilter  OutputFilterFunc
+	metadata      map[string]map[string]string
 }
 
 // Creates a new metric context. A metric context specifies a namespace
@@ -43,6 +44,7 @@ func NewMetricContext(namespace string) *MetricContext {
 	m.Gauges = make(map[string]*Gauge, 0)
 	m.BasicCounters = make(map[string]*BasicCounter, 0)
 	m.StatsTimers = make(map[string]*StatsTimer, 0)
+	m.metadata = make(map[string]map[string]string, 0)
 	m.OutputFilter = func(name string, v interface{}) bool {
 		return true
 	}
@@ -82,6 +84,25 @@ func (m *MetricContext) Unregister(v interface{}, name string) {
 	case *StatsTimer:
 		delete(m.StatsTimers, name)
 	}
+	delete(m.metadata, name)
+}
+
+// SetMetadata attaches descriptive key/value pairs (say name of the
+// container a cgroup belongs to) to a metric registered by name.
+// Metadata is reported along with the metric and removed on Unregister.
+func (m *MetricContext) SetMetadata(name string, md map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.metadata[name] = md
+}
+
+// Metadata returns key/value pairs attached to a metric by SetMetadata
+func (m *MetricContext) Metadata(name string) map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.metadata[name]
 }
 
 // HttpJsonHandler setups a handler for exposing metrics via JSON over HTTP
@@ -0,0 +1,296 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package container implements attribution of cgroups to containers and
+// kubernetes pods using local metadata written by container runtimes and
+// the kubelet
+package container
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// DefaultResolver is used by collectors to attribute cgroups to containers.
+// Replace it before starting collection to read metadata from a different
+// root (say host filesystem mounted inside a container).
+var DefaultResolver = NewResolver("/")
+
+// Container represents a container and the kubernetes pod it belongs to
+// as far as can be determined from local metadata
+type Container struct {
+	ID           string
+	Runtime      string // docker, containerd or crio; empty if unknown
+	Name         string
+	PodUID       string
+	PodName      string
+	PodNamespace string
+}
+
+// String returns a human readable name for the container. namespace/pod/container
+// is used for kubernetes pods and container name or short id otherwise.
+func (c *Container) String() string {
+	if c.PodName != "" {
+		name := c.PodNamespace + "/" + c.PodName
+		if c.Name != "" {
+			name += "/" + c.Name
+		}
+		return name
+	}
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.ID == "" {
+		return "pod" + c.PodUID
+	}
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	if c.Runtime == "" {
+		return id
+	}
+	return c.Runtime + ":" + id
+}
+
+// Metadata returns known attributes of the container as key/value pairs
+// suitable for metrics.MetricContext.SetMetadata
+func (c *Container) Metadata() map[string]string {
+	md := make(map[string]string)
+	for k, v := range map[string]string{
+		"container_id":      c.ID,
+		"container_runtime": c.Runtime,
+		"container_name":    c.Name,
+		"pod_uid":           c.PodUID,
+		"pod_name":          c.PodName,
+		"pod_namespace":     c.PodNamespace,
+	} {
+		if v != "" {
+			md[k] = v
+		}
+	}
+	return md
+}
+
+// Resolved reports if names for the container have been found
+func (c *Container) Resolved() bool {
+	return c.Name != "" || c.PodName != ""
+}
+
+// Resolver maps cgroup paths to containers. Resolved containers are cached.
+type Resolver struct {
+	root  string
+	mu    sync.Mutex
+	cache map[string]*Container
+}
+
+// NewResolver returns a Resolver reading runtime and kubelet metadata from
+// directories under root
+func NewResolver(root string) *Resolver {
+	r := new(Resolver)
+	r.root = root
+	r.cache = make(map[string]*Container)
+	return r
+}
+
+// maximum number of cgroups to remember
+const maxCacheEntries = 4096
+
+// Resolve returns the container the input cgroup path belongs to or nil
+// if the path does not follow any known container runtime convention.
+// Container names are filled in if metadata could be found.
+func (r *Resolver) Resolve(cgroup string) *Container {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.cache[cgroup]; ok {
+		return c
+	}
+	c := ParseCgroup(cgroup)
+	if c != nil {
+		r.lookup(c)
+	}
+	// metadata might show up a bit later than the cgroup for new
+	// containers - so only remember what is completely known
+	if c == nil || c.Resolved() {
+		if len(r.cache) >= maxCacheEntries {
+			r.cache = make(map[string]*Container)
+		}
+		r.cache[cgroup] = c
+	}
+	return c
+}
+
+// ResolveMetrics attributes a cgroup to a container and attaches its
+// names as metadata to metrics of v registered under prefix. Collectors
+// pass the container returned by the previous call, which is kept once
+// resolved; metadata of new containers might show up later than the
+// cgroup.
+func (r *Resolver) ResolveMetrics(c *Container, cgroup string, v misc.Interface,
+	m *metrics.MetricContext, prefix string) *Container {
+	if c != nil && c.Resolved() {
+		return c
+	}
+	c = r.Resolve(cgroup)
+	if c != nil {
+		misc.SetMetadata(v, m, prefix, c.Metadata())
+	}
+	return c
+}
+
+// cgroup path conventions used by docker, containerd, CRI-O and
+// the kubelet with both cgroupfs and systemd cgroup drivers
+var (
+	podRe        = regexp.MustCompile("^(?:kubepods-(?:[a-z]+-)?)?pod([0-9a-f_-]{36})(?:\\.slice)?$")
+	dockerRe     = regexp.MustCompile("^docker-([0-9a-f]{64})\\.scope$")
+	containerdRe = regexp.MustCompile("^cri-containerd-([0-9a-f]{64})\\.scope$")
+	crioRe       = regexp.MustCompile("^crio-(?:conmon-)?([0-9a-f]{64})(?:\\.scope)?$")
+	idRe         = regexp.MustCompile("^([0-9a-f]{64})$")
+)
+
+// ParseCgroup extracts container id, runtime and pod uid from a cgroup
+// path. nil is returned if the path does not belong to a container.
+func ParseCgroup(cgroup string) *Container {
+	c := new(Container)
+	parent := ""
+	for _, p := range strings.Split(cgroup, "/") {
+		if m := podRe.FindStringSubmatch(p); m != nil {
+			c.PodUID = strings.Replace(m[1], "_", "-", -1)
+		} else if m := dockerRe.FindStringSubmatch(p); m != nil {
+			c.ID, c.Runtime = m[1], "docker"
+		} else if m := containerdRe.FindStringSubmatch(p); m != nil {
+			c.ID, c.Runtime = m[1], "containerd"
+		} else if m := crioRe.FindStringSubmatch(p); m != nil {
+			c.ID, c.Runtime = m[1], "crio"
+		} else if m := idRe.FindStringSubmatch(p); m != nil {
+			c.ID = m[1]
+			if parent == "docker" {
+				c.Runtime = "docker"
+			}
+		}
+		parent = p
+	}
+	if c.ID == "" && c.PodUID == "" {
+		return nil
+	}
+	return c
+}
+
+// lookup fills in names of the container and its pod from runtime
+// configuration and kubelet log directories
+func (r *Resolver) lookup(c *Container) {
+	if c.ID != "" {
+		if c.Runtime == "" || c.Runtime == "docker" {
+			r.lookupDocker(c)
+		}
+		if !c.Resolved() && (c.Runtime == "" || c.Runtime == "containerd") {
+			r.lookupOCI(c, "run/containerd/io.containerd.runtime.v2.task/k8s.io/"+c.ID+"/config.json")
+		}
+		if !c.Resolved() && (c.Runtime == "" || c.Runtime == "crio") {
+			r.lookupOCI(c, "var/lib/containers/storage/overlay-containers/"+c.ID+"/userdata/config.json")
+		}
+		if !c.Resolved() {
+			r.lookupContainerLogs(c)
+		}
+	}
+	if c.PodName == "" && c.PodUID != "" {
+		r.lookupPodLogs(c)
+	}
+}
+
+// lookupDocker reads container name and kubernetes labels from
+// docker's container configuration
+func (r *Resolver) lookupDocker(c *Container) {
+	content, err := ioutil.ReadFile(filepath.Join(r.root,
+		"var/lib/docker/containers", c.ID, "config.v2.json"))
+	if err != nil {
+		return
+	}
+	var config struct {
+		Name   string
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if json.Unmarshal(content, &config) != nil {
+		return
+	}
+	c.Runtime = "docker"
+	c.Name = strings.TrimPrefix(config.Name, "/")
+	labels := config.Config.Labels
+	if labels["io.kubernetes.pod.name"] != "" {
+		c.Name = labels["io.kubernetes.container.name"]
+		c.PodName = labels["io.kubernetes.pod.name"]
+		c.PodNamespace = labels["io.kubernetes.pod.namespace"]
+		c.PodUID = labels["io.kubernetes.pod.uid"]
+	}
+}
+
+// lookupOCI reads kubernetes annotations from OCI runtime spec written
+// by containerd or CRI-O
+func (r *Resolver) lookupOCI(c *Container, path string) {
+	content, err := ioutil.ReadFile(filepath.Join(r.root, path))
+	if err != nil {
+		return
+	}
+	var spec struct {
+		Annotations map[string]string
+	}
+	if json.Unmarshal(content, &spec) != nil {
+		return
+	}
+	a := spec.Annotations
+	// CRI-O (and dockershim labels)
+	if a["io.kubernetes.pod.name"] != "" {
+		c.Runtime = "crio"
+		c.Name = a["io.kubernetes.container.name"]
+		c.PodName = a["io.kubernetes.pod.name"]
+		c.PodNamespace = a["io.kubernetes.pod.namespace"]
+		c.PodUID = a["io.kubernetes.pod.uid"]
+	}
+	// containerd CRI plugin
+	if a["io.kubernetes.cri.sandbox-name"] != "" {
+		c.Runtime = "containerd"
+		c.Name = a["io.kubernetes.cri.container-name"]
+		c.PodName = a["io.kubernetes.cri.sandbox-name"]
+		c.PodNamespace = a["io.kubernetes.cri.sandbox-namespace"]
+		c.PodUID = a["io.kubernetes.cri.sandbox-uid"]
+	}
+}
+
+// lookupContainerLogs finds container and pod names from symlinks
+// maintained by the kubelet: <pod>_<namespace>_<container>-<id>.log
+func (r *Resolver) lookupContainerLogs(c *Container) {
+	matches, err := filepath.Glob(filepath.Join(r.root,
+		"var/log/containers", "*-"+c.ID+".log"))
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(matches[0]), "-"+c.ID+".log")
+	f := strings.SplitN(name, "_", 3)
+	if len(f) != 3 {
+		return
+	}
+	c.PodName, c.PodNamespace, c.Name = f[0], f[1], f[2]
+}
+
+// lookupPodLogs finds pod name and namespace from directories
+// maintained by the kubelet: <namespace>_<pod>_<uid>
+func (r *Resolver) lookupPodLogs(c *Container) {
+	matches, err := filepath.Glob(filepath.Join(r.root,
+		"var/log/pods", "*_"+c.PodUID))
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	f := strings.SplitN(filepath.Base(matches[0]), "_", 3)
+	if len(f) != 3 {
+		return
+	}
+	c.PodNamespace, c.PodName = f[0], f[1]
+}
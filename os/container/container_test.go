@@ -0,0 +1,44 @@
+// Copyright (c) 2015 Square, Inc
+
+package container
+
+import "testing"
+
+var resolveTests = []struct {
+	cgroup   string
+	expected string
+}{
+	// docker with cgroupfs driver
+	{"/docker/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "web"},
+	// docker with systemd driver, unknown container
+	{"/system.slice/docker-ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff.scope", "docker:ffffffffffff"},
+	// kubernetes pods
+	{"/kubepods/burstable/pod8f2a9c3e-1d2b-4c5e-9f7a-0b1c2d3e4f5a/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "payments/api-7c9d8f-2xkqp/api"},
+	{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/cri-containerd-cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc.scope", "batch/worker-0/worker"},
+	{"/kubepods.slice/kubepods-pod5a4b3c2d_1e0f_4a9b_8c7d_6e5f4a3b2c1d.slice/crio-dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd.scope", "cache/redis-0/redis"},
+	{"/kubepods/besteffort/pod1b4e28ba-2fa1-11d2-883f-0016d3cca427/eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee", "kube-system/coredns-5d78c9869d-x7k2p/coredns"},
+	{"/kubepods/besteffort/pod1b4e28ba-2fa1-11d2-883f-0016d3cca427", "kube-system/coredns-5d78c9869d-x7k2p"},
+}
+
+func TestResolve(t *testing.T) {
+	r := NewResolver("testdata/t0")
+	for _, tt := range resolveTests {
+		c := r.Resolve(tt.cgroup)
+		if c == nil {
+			t.Errorf("Resolve(%v) => nil, want %v", tt.cgroup, tt.expected)
+			continue
+		}
+		if c.String() != tt.expected {
+			t.Errorf("Resolve(%v) => %v, want %v", tt.cgroup, c.String(), tt.expected)
+		}
+	}
+}
+
+func TestResolveNonContainer(t *testing.T) {
+	r := NewResolver("testdata/t0")
+	for _, cgroup := range []string{"/", "/system.slice/sshd.service", "/user.slice/user-1000.slice"} {
+		if c := r.Resolve(cgroup); c != nil {
+			t.Errorf("Resolve(%v) => %v, want nil", cgroup, c.String())
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/container"
 	"github.com/square/inspect/os/misc"
 )
 
@@ -110,11 +111,14 @@ type PerCgroupStat struct {
 	KernelCount    *metrics.Gauge
 	TotalCount     *metrics.Gauge
 	ThrottleCount  *metrics.Gauge
+	// Container is the container this cgroup belongs to (nil otherwise)
+	Container *container.Container
 	//
 	m          *metrics.MetricContext
 	path       string
 	mountpoint string
 	prefix     string
+	rel        string
 }
 
 // NewPerCgroupStat registers with metricscontext for a particular cgroup
@@ -126,6 +130,7 @@ func NewPerCgroupStat(m *metrics.MetricContext, path, mp string) *PerCgroupStat
 	// initialize all metrics and register them
 	// XXX: Handle errors
 	rel, _ := filepath.Rel(mp, path)
+	c.rel = rel
 	c.prefix = "cpustat.cgroup." + rel
 	misc.InitializeMetrics(c, m, c.prefix, true)
 	return c
@@ -179,6 +184,9 @@ func (s *PerCgroupStat) Kernel() float64 {
 // Collect reads cpu.stat for cgroups and per process cpu.stat
 // entries for all processes in the cgroup
 func (s *PerCgroupStat) Collect() {
+	s.Container = container.DefaultResolver.ResolveMetrics(s.Container, "/"+s.rel,
+		s, s.m, s.prefix)
+
 	file, err := os.Open(s.path + "/" + "cpu.stat")
 	defer file.Close()
 	if err != nil {
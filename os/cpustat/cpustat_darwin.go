@@ -114,6 +114,26 @@ func (s *CPUStat) Kernel() float64 {
 	return s.All.Kernel() * s.Total()
 }
 
+// Iowait returns time spent waiting for IO; not accounted by mach
+func (s *CPUStat) Iowait() float64 {
+	return 0
+}
+
+// Irq returns time spent servicing interrupts; not accounted by mach
+func (s *CPUStat) Irq() float64 {
+	return 0
+}
+
+// Softirq returns time spent servicing softirqs; not accounted by mach
+func (s *CPUStat) Softirq() float64 {
+	return 0
+}
+
+// Steal returns time stolen by a hypervisor; not accounted by mach
+func (s *CPUStat) Steal() float64 {
+	return 0
+}
+
 // Total returns maximum amount of work that can done over sampling interval
 // Units: # of CPUs
 func (s *CPUStat) Total() float64 {
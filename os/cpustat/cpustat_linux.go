@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"sort"
 	"time"
 
 	"github.com/square/inspect/metrics"
@@ -92,6 +93,32 @@ func (s *CPUStat) Kernel() float64 {
 	return s.All.Kernel() * float64(len(s.cpus))
 }
 
+// Iowait returns time spent idle waiting for IO over sampling interval
+// Units: # of Logical CPUs
+func (s *CPUStat) Iowait() float64 {
+	return s.All.IowaitUsage() * float64(len(s.cpus))
+}
+
+// Irq returns time spent servicing hardware interrupts over sampling
+// interval
+// Units: # of Logical CPUs
+func (s *CPUStat) Irq() float64 {
+	return s.All.IrqUsage() * float64(len(s.cpus))
+}
+
+// Softirq returns time spent servicing softirqs over sampling interval
+// Units: # of Logical CPUs
+func (s *CPUStat) Softirq() float64 {
+	return s.All.SoftirqUsage() * float64(len(s.cpus))
+}
+
+// Steal returns time the hypervisor ran other guests while this one
+// wanted to run over sampling interval
+// Units: # of Logical CPUs
+func (s *CPUStat) Steal() float64 {
+	return s.All.StealUsage() * float64(len(s.cpus))
+}
+
 // Total returns maximum work that can be done over sampling interval
 // Units: # of Logical CPUs
 func (s *CPUStat) Total() float64 {
@@ -113,90 +140,148 @@ func (s *CPUStat) PerCPUStat(cpu string) *PerCPU {
 	return s.cpus[cpu]
 }
 
+// PerCPUStats returns per-CPU stats for all CPUs ordered by CPU number
+func (s *CPUStat) PerCPUStats() []*PerCPU {
+	var v []*PerCPU
+	for _, o := range s.cpus {
+		v = append(v, o)
+	}
+	sort.Slice(v, func(i, j int) bool {
+		return misc.ParseUint(v[i].Name[3:]) < misc.ParseUint(v[j].Name[3:])
+	})
+	return v
+}
+
 // PerCPU represents metrics about individual CPU performance
 // and also provides few summary statistics
 type PerCPU struct {
-	User        *metrics.Counter
-	UserLowPrio *metrics.Counter
-	System      *metrics.Counter
-	Idle        *metrics.Counter
-	Iowait      *metrics.Counter
-	Irq         *metrics.Counter
-	Softirq     *metrics.Counter
-	Steal       *metrics.Counter
-	Guest       *metrics.Counter
-	Total       *metrics.Counter // total jiffies
+	User         *metrics.Counter
+	UserLowPrio  *metrics.Counter
+	System       *metrics.Counter
+	Idle         *metrics.Counter
+	Iowait       *metrics.Counter
+	Irq          *metrics.Counter
+	Softirq      *metrics.Counter
+	Steal        *metrics.Counter
+	Guest        *metrics.Counter // included in User
+	GuestLowPrio *metrics.Counter // included in UserLowPrio
+	Total        *metrics.Counter // total jiffies
 	// Computed stats
 	UserspaceCount *metrics.Gauge
 	KernelCount    *metrics.Gauge
 	UsageCount     *metrics.Gauge
+	IowaitCount    *metrics.Gauge
+	IrqCount       *metrics.Gauge
+	SoftirqCount   *metrics.Gauge
+	StealCount     *metrics.Gauge
 	TotalCount     *metrics.Gauge
+	Name           string // cpu, cpu0, cpu1...
 }
 
 // NewPerCPU returns a struct representing counters for
 // per CPU statistics
 func NewPerCPU(m *metrics.MetricContext, name string) *PerCPU {
 	o := new(PerCPU)
+	o.Name = name
 
 	// initialize all metrics and register them
 	misc.InitializeMetrics(o, m, "cpustat."+name, true)
 	return o
 }
 
-// Usage returns total work done over sampling interval
+// Usage returns total work done over sampling interval including
+// time spent servicing interrupts
 // Units: # of Logical CPUs
 func (o *PerCPU) Usage() float64 {
-	u := o.User.ComputeRate()
-	n := o.UserLowPrio.ComputeRate()
-	s := o.System.ComputeRate()
-	t := o.Total.ComputeRate()
-
-	if u != math.NaN() && n != math.NaN() && s != math.NaN() &&
-		t != math.NaN() && t > 0 {
-		return (u + s + n) / t
-	}
-	return math.NaN()
+	return o.share(o.User, o.UserLowPrio, o.System, o.Irq, o.Softirq)
 }
 
 // UserSpace returns total work done over sampling interval in userspace
 // Units: # of Logical CPUs
 func (o *PerCPU) UserSpace() float64 {
-	u := o.User.ComputeRate()
-	n := o.UserLowPrio.ComputeRate()
-	t := o.Total.ComputeRate()
-	if u != math.NaN() && t != math.NaN() && n != math.NaN() && t > 0 {
-		return (u + n) / t
-	}
-	return math.NaN()
+	return o.share(o.User, o.UserLowPrio)
 }
 
 // Kernel returns total work done over sampling interval in kernel
+// including time spent servicing interrupts
 // Units: # of Logical CPUs
 func (o *PerCPU) Kernel() float64 {
-	s := o.System.ComputeRate()
+	return o.share(o.System, o.Irq, o.Softirq)
+}
+
+// SystemUsage returns work done over sampling interval in kernel
+// excluding time spent servicing interrupts
+// Units: # of Logical CPUs
+func (o *PerCPU) SystemUsage() float64 {
+	return o.share(o.System)
+}
+
+// IowaitUsage returns time spent idle waiting for IO over sampling interval
+// Units: # of Logical CPUs
+func (o *PerCPU) IowaitUsage() float64 {
+	return o.share(o.Iowait)
+}
+
+// IrqUsage returns time spent servicing hardware interrupts over sampling
+// interval
+// Units: # of Logical CPUs
+func (o *PerCPU) IrqUsage() float64 {
+	return o.share(o.Irq)
+}
+
+// SoftirqUsage returns time spent servicing softirqs over sampling interval
+// Units: # of Logical CPUs
+func (o *PerCPU) SoftirqUsage() float64 {
+	return o.share(o.Softirq)
+}
+
+// StealUsage returns time the hypervisor ran other guests while this CPU
+// wanted to run over sampling interval
+// Units: # of Logical CPUs
+func (o *PerCPU) StealUsage() float64 {
+	return o.share(o.Steal)
+}
+
+// Unexported functions
+
+// share returns sum of rates of counters as a fraction of total jiffies
+func (o *PerCPU) share(counters ...*metrics.Counter) float64 {
 	t := o.Total.ComputeRate()
-	if s != math.NaN() && t != math.NaN() && t > 0 {
-		return (s / t)
+	if math.IsNaN(t) || t <= 0 {
+		return math.NaN()
 	}
-	return math.NaN()
+	var sum float64
+	for _, c := range counters {
+		sum += c.ComputeRate()
+	}
+	return sum / t
 }
 
-// Unexported functions
+// parseCPUline parses a cpu line of /proc/stat. Older kernels report
+// fewer columns; guest time is already accounted in user time.
 func parseCPUline(s *PerCPU, f []string) {
-	s.User.Set(misc.ParseUint(f[1]))
-	s.UserLowPrio.Set(misc.ParseUint(f[2]))
-	s.System.Set(misc.ParseUint(f[3]))
-	s.Idle.Set(misc.ParseUint(f[4]))
-	s.Iowait.Set(misc.ParseUint(f[5]))
-	s.Irq.Set(misc.ParseUint(f[6]))
-	s.Softirq.Set(misc.ParseUint(f[7]))
-	s.Steal.Set(misc.ParseUint(f[8]))
-	s.Guest.Set(misc.ParseUint(f[9]))
-	s.Total.Set(s.User.Get() + s.UserLowPrio.Get() + s.System.Get() + s.Idle.Get())
+	counters := []*metrics.Counter{s.User, s.UserLowPrio, s.System, s.Idle,
+		s.Iowait, s.Irq, s.Softirq, s.Steal, s.Guest, s.GuestLowPrio}
+	var total uint64
+	for i, c := range counters {
+		var v uint64
+		if i+1 < len(f) {
+			v = misc.ParseUint(f[i+1])
+		}
+		c.Set(v)
+		if c != s.Guest && c != s.GuestLowPrio {
+			total += v
+		}
+	}
+	s.Total.Set(total)
 }
 
 func populateComputedStats(s *PerCPU, mult float64) {
 	s.UserspaceCount.Set(s.UserSpace() * mult)
 	s.KernelCount.Set(s.Kernel() * mult)
 	s.UsageCount.Set(s.Usage() * mult)
+	s.IowaitCount.Set(s.IowaitUsage() * mult)
+	s.IrqCount.Set(s.IrqUsage() * mult)
+	s.SoftirqCount.Set(s.SoftirqUsage() * mult)
+	s.StealCount.Set(s.StealUsage() * mult)
 }
@@ -3,6 +3,7 @@
 package cpustat
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -23,3 +24,47 @@ func TestCPUUsage(t *testing.T) {
 		t.Errorf("CPU user counter: %v expected: %v", actual, expected)
 	}
 }
+
+func TestCPUBreakdown(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	cstat := &CPUStat{All: NewPerCPU(m, "cpu"), m: m, cpus: make(map[string]*PerCPU)}
+	root = "testdata/t2/"
+	cstat.Collect()
+	root = "testdata/t3/"
+	cstat.Collect()
+	// 100 jiffies: 1 user, 5 irq, 5 softirq, 20 iowait, 30 steal, 39 idle
+	if actual := cstat.All.Total.Get(); actual != 2626661392 {
+		t.Errorf("CPU total counter: %v expected: 2626661392", actual)
+	}
+	cases := []struct {
+		name     string
+		actual   float64
+		expected float64
+	}{
+		{"usage", cstat.Usage(), 0.11},
+		{"userspace", cstat.UserSpace(), 0.01},
+		{"kernel", cstat.Kernel(), 0.10},
+		{"iowait", cstat.Iowait(), 0.20},
+		{"irq", cstat.Irq(), 0.05},
+		{"softirq", cstat.Softirq(), 0.05},
+		{"steal", cstat.Steal(), 0.30},
+	}
+	for _, c := range cases {
+		if math.Abs(c.actual-c.expected) > 1e-9 {
+			t.Errorf("CPU %s: %v expected: %v", c.name, c.actual, c.expected)
+		}
+	}
+	cpus := cstat.PerCPUStats()
+	if len(cpus) != 1 || cpus[0].Name != "cpu0" {
+		t.Fatalf("per-CPU stats: %v expected: cpu0", cpus)
+	}
+	if actual := cpus[0].StealCount.Get(); math.Abs(actual-0.30) > 1e-9 {
+		t.Errorf("cpu0 steal: %v expected: 0.3", actual)
+	}
+	// columns of the per-CPU breakdown add up to usage
+	cpu := cpus[0]
+	breakdown := cpu.UserSpace() + cpu.SystemUsage() + cpu.IrqUsage() + cpu.SoftirqUsage()
+	if math.Abs(breakdown-cpu.Usage()) > 1e-9 {
+		t.Errorf("cpu0 usr+sys+irq+soft: %v expected: %v", breakdown, cpu.Usage())
+	}
+}
@@ -6,11 +6,10 @@ package diskstat
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
-	"path"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/square/inspect/metrics"
@@ -22,9 +21,13 @@ var root = "/"
 // DiskStat represents statistics collected for all disks (block devices) present
 // on the current operating system.
 type DiskStat struct {
-	Disks   map[string]*PerDiskStat
-	m       *metrics.MetricContext
-	blkdevs map[string]bool
+	Disks        map[string]*PerDiskStat
+	Devices      map[string]*BlockDevice // topology of all block devices
+	m            *metrics.MetricContext
+	mu           sync.Mutex        // guards devnums, Devices and Disks
+	devnums      map[string]string // major:minor to kernel name
+	partitions   bool
+	deviceMapper bool
 }
 
 // New registers statistics with metrics context and starts collection of metrics
@@ -32,8 +35,9 @@ type DiskStat struct {
 func New(m *metrics.MetricContext, Step time.Duration) *DiskStat {
 	s := new(DiskStat)
 	s.Disks = make(map[string]*PerDiskStat, 6)
+	s.devnums = make(map[string]string)
 	s.m = m
-	s.RefreshBlkDevList() // perhaps call this once in a while
+	s.RefreshBlkDevList()
 	ticker := time.NewTicker(Step)
 	go func() {
 		for _ = range ticker.C {
@@ -43,6 +47,18 @@ func New(m *metrics.MetricContext, Step time.Duration) *DiskStat {
 	return s
 }
 
+// SetIncludePartitions sets whether statistics are collected for
+// partitions in addition to whole disks
+func (s *DiskStat) SetIncludePartitions(include bool) {
+	s.partitions = include
+}
+
+// SetIncludeDeviceMapper sets whether statistics are collected for
+// device mapper targets (LVM, LUKS...)
+func (s *DiskStat) SetIncludeDeviceMapper(include bool) {
+	s.deviceMapper = include
+}
+
 // Return list of disks sorted by Usage
 type byUsage []*PerDiskStat
 
@@ -54,27 +70,49 @@ func (a byUsage) Less(i, j int) bool { return a[i].Usage() > a[j].Usage() }
 // by usage
 func (s *DiskStat) ByUsage() []*PerDiskStat {
 	var v []*PerDiskStat
+	s.mu.Lock()
 	for _, o := range s.Disks {
 		if !math.IsNaN(o.Usage()) {
 			v = append(v, o)
 		}
 	}
+	s.mu.Unlock()
 	sort.Sort(byUsage(v))
 	return v
 }
 
-// RefreshBlkDevList walks through /sys/block and updates list of
-// block devices.
-func (s *DiskStat) RefreshBlkDevList() {
-	var blkdevs = make(map[string]bool)
-	// block devices
-	o, err := ioutil.ReadDir(root + "sys/block")
-	if err == nil {
-		for _, d := range o {
-			blkdevs[path.Base(d.Name())] = true
-		}
+// ByDevNum returns disks backing the block device identified by
+// major:minor (as found in st_dev of files on a mounted filesystem).
+// If statistics are not collected for the device itself, the disk a
+// partition belongs to or devices a dm/md device is built on are
+// returned instead.
+func (s *DiskStat) ByDevNum(devnum string) []*PerDiskStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.devnums[devnum]
+	if !ok {
+		return nil
 	}
-	s.blkdevs = blkdevs
+	return s.backing(name, 0)
+}
+
+// IsRotational returns true if the disk or any device it is built on
+// is a rotational disk. Other devices (say NVMe or SSDs, and dm/md
+// devices built on them) serve requests in parallel and can be 100%
+// busy with plenty of capacity left.
+func (s *DiskStat) IsRotational(d *PerDiskStat) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotational(d.Name, 0)
+}
+
+// RefreshBlkDevList walks through /sys/block and updates topology
+// of block devices.
+func (s *DiskStat) RefreshBlkDevList() {
+	devices := readTopology()
+	s.mu.Lock()
+	s.Devices = devices
+	s.mu.Unlock()
 }
 
 // Collect walks through /proc/diskstats and updates relevant metrics
@@ -87,27 +125,46 @@ func (s *DiskStat) Collect() {
 	var blkdev string
 	var major, minor uint64
 	var f [11]uint64
+	refreshed := false
+	// built afresh every step and published once complete, so that
+	// ByDevNum never reads a map which is being written
+	devnums := make(map[string]string, len(s.devnums))
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		fmt.Sscanf(scanner.Text(),
 			"%d %d %s %d %d %d %d %d %d %d %d %d %d %d",
 			&major, &minor, &blkdev, &f[0], &f[1], &f[2], &f[3],
 			&f[4], &f[5], &f[6], &f[7], &f[8], &f[9], &f[10])
-		// skip loop/ram/dm drives
-		if major == 1 || major == 7 || major == 253 {
+		// skip loop/ram drives
+		if major == 1 || major == 7 {
 			continue
 		}
-		// skip collecting for individual partitions
-		_, ok := s.blkdevs[blkdev]
-		if !ok {
+		devnum := fmt.Sprintf("%d:%d", major, minor)
+		devnums[devnum] = blkdev
+		d, ok := s.Devices[blkdev]
+		// devices (say LVM volumes) may show up after we started
+		if !ok && !refreshed {
+			s.RefreshBlkDevList()
+			refreshed = true
+			d, ok = s.Devices[blkdev]
+		}
+		if !ok || !s.wanted(d) {
 			continue
 		}
 		o, ok := s.Disks[blkdev]
 		if !ok {
 			o = NewPerDiskStat(s.m, blkdev)
+			s.mu.Lock()
 			s.Disks[blkdev] = o
+			s.mu.Unlock()
+		}
+		o.Device = d
+		o.DevNum = devnum
+		disk := blkdev
+		if d.IsPartition() {
+			disk = d.Parent
 		}
-		sectorSize := misc.ReadUintFromFile(root + "sys/block/" + blkdev + "/queue/hw_sector_size")
+		sectorSize := misc.ReadUintFromFile(root + "sys/block/" + disk + "/queue/hw_sector_size")
 		o.ReadCompleted.Set(f[0])
 		o.ReadMerged.Set(f[1])
 		o.ReadSectors.Set(f[2])
@@ -120,7 +177,11 @@ func (s *DiskStat) Collect() {
 		o.IOSpentMsecs.Set(f[9])
 		o.WeightedIOSpentMsecs.Set(f[10])
 		o.SectorSize.Set(float64(sectorSize))
+		o.computeRates()
 	}
+	s.mu.Lock()
+	s.devnums = devnums
+	s.mu.Unlock()
 }
 
 // PerDiskStat represents disk statistics for a particular disk
@@ -137,8 +198,18 @@ type PerDiskStat struct {
 	IOSpentMsecs         *metrics.Counter
 	WeightedIOSpentMsecs *metrics.Counter
 	SectorSize           *metrics.Gauge
+	ReadIOPS             *metrics.Gauge // r/s
+	WriteIOPS            *metrics.Gauge // w/s
+	ReadKBps             *metrics.Gauge // rkB/s
+	WriteKBps            *metrics.Gauge // wkB/s
+	ReadAwait            *metrics.Gauge // r_await in milliseconds
+	WriteAwait           *metrics.Gauge // w_await in milliseconds
+	AvgQueueSize         *metrics.Gauge // avgqu-sz
+	AvgRequestSize       *metrics.Gauge // average request size in kB
 	m                    *metrics.MetricContext
 	Name                 string
+	DevNum               string // major:minor
+	Device               *BlockDevice
 }
 
 // NewPerDiskStat registers with metriccontext for a particular disk (block device)
@@ -155,3 +226,114 @@ func NewPerDiskStat(m *metrics.MetricContext, blkdev string) *PerDiskStat {
 func (s *PerDiskStat) Usage() float64 {
 	return ((s.IOSpentMsecs.ComputeRate()) / 1000) * 100
 }
+
+// DisplayName returns device mapper name of the device if any
+// or its kernel name
+func (s *PerDiskStat) DisplayName() string {
+	if s.Device != nil && s.Device.Alias != "" {
+		return s.Device.Alias
+	}
+	return s.Name
+}
+
+// Await returns average time in milliseconds for reads and writes
+// to be served including time spent in queue
+func (s *PerDiskStat) Await() float64 {
+	r := s.ReadIOPS.Get()
+	w := s.WriteIOPS.Get()
+	if r+w == 0 {
+		return 0
+	}
+	return (s.ReadAwait.Get()*r + s.WriteAwait.Get()*w) / (r + w)
+}
+
+// Unexported functions
+
+// sectors in /proc/diskstats are always 512 bytes irrespective of
+// sector size of the device
+const diskstatSectorSize = 512
+
+// wanted returns true if statistics are to be collected for
+// the block device
+func (s *DiskStat) wanted(d *BlockDevice) bool {
+	switch {
+	case d.Hidden:
+		return false
+	case d.IsPartition():
+		return s.partitions
+	case d.IsDeviceMapper():
+		return s.deviceMapper
+	}
+	return true
+}
+
+// backing returns disks for which statistics are collected that
+// back the named block device
+func (s *DiskStat) backing(name string, depth int) []*PerDiskStat {
+	if o, ok := s.Disks[name]; ok {
+		return []*PerDiskStat{o}
+	}
+	d, ok := s.Devices[name]
+	// guard against loops in sysfs
+	if !ok || depth > 8 {
+		return nil
+	}
+	if d.IsPartition() {
+		return s.backing(d.Parent, depth+1)
+	}
+	var v []*PerDiskStat
+	for _, slave := range d.Slaves {
+		v = append(v, s.backing(slave, depth+1)...)
+	}
+	return v
+}
+
+// rotational returns true if the named block device or any device it
+// is built on is a rotational disk
+func (s *DiskStat) rotational(name string, depth int) bool {
+	d, ok := s.Devices[name]
+	// guard against loops in sysfs; assume the worst about
+	// devices which are not known
+	if !ok || depth > 8 {
+		return true
+	}
+	if d.IsPartition() {
+		return s.rotational(d.Parent, depth+1)
+	}
+	// flags of dm/md devices are not reliably inherited
+	if len(d.Slaves) == 0 {
+		return d.Rotational
+	}
+	for _, slave := range d.Slaves {
+		if s.rotational(slave, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// computeRates derives iostat like metrics from counters
+func (s *PerDiskStat) computeRates() {
+	reads := s.ReadCompleted.ComputeRate()
+	writes := s.WriteCompleted.ComputeRate()
+	readKB := s.ReadSectors.ComputeRate() * diskstatSectorSize / 1024
+	writeKB := s.WriteSectors.ComputeRate() * diskstatSectorSize / 1024
+	readSpent := s.ReadSpentMsecs.ComputeRate()
+	writeSpent := s.WriteSpentMsecs.ComputeRate()
+	s.ReadIOPS.Set(reads)
+	s.WriteIOPS.Set(writes)
+	s.ReadKBps.Set(readKB)
+	s.WriteKBps.Set(writeKB)
+	s.ReadAwait.Set(ratio(readSpent, reads))
+	s.WriteAwait.Set(ratio(writeSpent, writes))
+	s.AvgQueueSize.Set(s.WeightedIOSpentMsecs.ComputeRate() / 1000)
+	s.AvgRequestSize.Set(ratio(readKB+writeKB, reads+writes))
+}
+
+// ratio returns a/b or zero if there was no activity
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
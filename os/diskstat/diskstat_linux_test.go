@@ -3,6 +3,7 @@
 package diskstat
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -23,3 +24,80 @@ func TestDiskStat(t *testing.T) {
 		t.Errorf("Diskstat: %v expected: %v", actual, expected)
 	}
 }
+
+func TestDiskStatRates(t *testing.T) {
+	root = "testdata/t2/"
+	m := metrics.NewMetricContext("system")
+	s := &DiskStat{Disks: make(map[string]*PerDiskStat), devnums: make(map[string]string), m: m}
+	s.RefreshBlkDevList()
+	s.Collect()
+	time.Sleep(time.Millisecond * 300)
+	root = "testdata/t3/"
+	s.Collect()
+	d := s.Disks["sda"]
+	if actual := d.WriteAwait.Get(); math.Abs(actual-10) > 0.001 {
+		t.Errorf("Diskstat w_await: %v expected: 10", actual)
+	}
+	if actual := d.AvgRequestSize.Get(); math.Abs(actual-5.6) > 0.001 {
+		t.Errorf("Diskstat avg request size: %v expected: 5.6", actual)
+	}
+	if actual := d.ReadAwait.Get(); actual != 0 {
+		t.Errorf("Diskstat r_await: %v expected: 0", actual)
+	}
+}
+
+func TestDiskStatTopology(t *testing.T) {
+	root = "testdata/t4/"
+	m := metrics.NewMetricContext("system")
+	s := &DiskStat{Disks: make(map[string]*PerDiskStat), devnums: make(map[string]string), m: m}
+	s.RefreshBlkDevList()
+	s.Collect()
+	if len(s.Disks) != 1 || s.Disks["sda"] == nil {
+		t.Fatalf("Expected only sda to be collected, got %v", s.Disks)
+	}
+	// filesystem on LVM volume built on a partition of sda
+	disks := s.ByDevNum("253:0")
+	if len(disks) != 1 || disks[0].Name != "sda" {
+		t.Errorf("Expected dm-0 to be backed by sda, got %v", disks)
+	}
+	s.SetIncludeDeviceMapper(true)
+	s.SetIncludePartitions(true)
+	s.Collect()
+	if len(s.Disks) != 4 {
+		t.Errorf("Expected 4 devices to be collected, got %v", len(s.Disks))
+	}
+	disks = s.ByDevNum("253:0")
+	if len(disks) != 1 || disks[0].DisplayName() != "vg0-root" {
+		t.Errorf("Expected dm-0 to be named vg0-root, got %v", disks)
+	}
+}
+
+func TestDiskStatRotational(t *testing.T) {
+	root = "testdata/t5/"
+	m := metrics.NewMetricContext("system")
+	s := &DiskStat{Disks: make(map[string]*PerDiskStat), devnums: make(map[string]string), m: m}
+	s.SetIncludeDeviceMapper(true)
+	s.SetIncludePartitions(true)
+	s.RefreshBlkDevList()
+	s.Collect()
+	// md0 is built on a partition of sda and on nvme0n1 and claims to
+	// be non-rotational itself
+	expected := map[string]bool{
+		"sda":       true,
+		"sda1":      true,
+		"nvme0n1":   false,
+		"nvme0n1p1": false,
+		"dm-0":      false,
+		"md0":       true,
+	}
+	for name, rotational := range expected {
+		d, ok := s.Disks[name]
+		if !ok {
+			t.Errorf("Expected %v to be collected", name)
+			continue
+		}
+		if actual := s.IsRotational(d); actual != rotational {
+			t.Errorf("Rotational %v: %v expected: %v", name, actual, rotational)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015 Square, Inc
+
+package diskstat
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// BlockDevice represents a block device and its relationship to other
+// block devices as found in sysfs
+type BlockDevice struct {
+	Name   string   // kernel name, say sda1 or dm-0
+	Alias  string   // device mapper name, say vg0-root
+	Parent string   // disk a partition belongs to
+	Slaves     []string // devices a dm or md device is built on
+	Hidden     bool     // say paths of multipathed NVMe namespaces
+	Rotational bool     // spinning disk serving one request at a time
+}
+
+// IsPartition returns true if the device is a partition of a disk
+func (d *BlockDevice) IsPartition() bool {
+	return d.Parent != ""
+}
+
+// IsDeviceMapper returns true if the device is a device mapper
+// target (LVM, LUKS, multipath...)
+func (d *BlockDevice) IsDeviceMapper() bool {
+	return strings.HasPrefix(d.Name, "dm-")
+}
+
+// readTopology walks through /sys/block and returns all block devices
+// including partitions keyed by kernel name
+func readTopology() map[string]*BlockDevice {
+	devices := make(map[string]*BlockDevice)
+	disks, err := ioutil.ReadDir(root + "sys/block")
+	if err != nil {
+		return devices
+	}
+	for _, disk := range disks {
+		name := disk.Name()
+		dir := root + "sys/block/" + name + "/"
+		d := &BlockDevice{Name: name}
+		d.Alias = readString(dir + "dm/name")
+		d.Hidden = readString(dir+"hidden") == "1"
+		// assume the worst if the queue can't be read
+		d.Rotational = readString(dir+"queue/rotational") != "0"
+		if slaves, err := ioutil.ReadDir(dir + "slaves"); err == nil {
+			for _, slave := range slaves {
+				d.Slaves = append(d.Slaves, slave.Name())
+			}
+		}
+		devices[name] = d
+		// partitions are subdirectories named after the disk
+		// (sda1, nvme0n1p1) with a partition attribute
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), name) {
+				continue
+			}
+			if _, err := os.Stat(dir + e.Name() + "/partition"); err == nil {
+				devices[e.Name()] = &BlockDevice{Name: e.Name(), Parent: name}
+			}
+		}
+	}
+	return devices
+}
+
+// readString returns trimmed contents of a sysfs attribute
+func readString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package fdstat implements metrics collection for system wide usage
+// of file handles and inodes
+package fdstat
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// FDStat represents allocated file handles and inodes as reported
+// by /proc/sys/fs/file-nr and /proc/sys/fs/inode-nr
+type FDStat struct {
+	Allocated  *metrics.Gauge // allocated file handles
+	Unused     *metrics.Gauge // allocated but unused file handles
+	Max        *metrics.Gauge // fs.file-max
+	Inodes     *metrics.Gauge
+	FreeInodes *metrics.Gauge
+	m          *metrics.MetricContext
+}
+
+// New starts metrics collection every Step and registers with
+// metricscontext
+func New(m *metrics.MetricContext, Step time.Duration) *FDStat {
+	s := new(FDStat)
+	s.m = m
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s, m, "fdstat", true)
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect populates FDStat by reading /proc/sys/fs/file-nr and
+// /proc/sys/fs/inode-nr
+func (s *FDStat) Collect() {
+	if content, err := ioutil.ReadFile(root + "proc/sys/fs/file-nr"); err == nil {
+		f := strings.Fields(string(content))
+		if len(f) > 2 {
+			s.Allocated.Set(float64(misc.ParseUint(f[0])))
+			s.Unused.Set(float64(misc.ParseUint(f[1])))
+			s.Max.Set(float64(misc.ParseUint(f[2])))
+		}
+	}
+	if content, err := ioutil.ReadFile(root + "proc/sys/fs/inode-nr"); err == nil {
+		f := strings.Fields(string(content))
+		if len(f) > 1 {
+			s.Inodes.Set(float64(misc.ParseUint(f[0])))
+			s.FreeInodes.Set(float64(misc.ParseUint(f[1])))
+		}
+	}
+}
+
+// Usage returns file handles in use as percentage of fs.file-max
+func (s *FDStat) Usage() float64 {
+	return ((s.Allocated.Get() - s.Unused.Get()) / s.Max.Get()) * 100
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2015 Square, Inc
+
+package fdstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestFDStat(t *testing.T) {
+	root = "testdata/t0/"
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	stat := New(m, time.Millisecond*50)
+	var expected = 51.171875
+	actual := stat.Usage()
+	if actual != expected {
+		t.Errorf("File handle usage: %v expected: %v", actual, expected)
+	}
+	expected = 2048
+	actual = stat.FreeInodes.Get()
+	if actual != expected {
+		t.Errorf("Free inodes: %v expected: %v", actual, expected)
+	}
+}
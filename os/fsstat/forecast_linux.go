@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Square, Inc
+
+package fsstat
+
+import (
+	"math"
+	"time"
+
+	"github.com/square/inspect/os/misc"
+)
+
+// DefaultForecastWindow is the amount of history used to estimate
+// how fast filesystems fill up
+const DefaultForecastWindow = 30 * time.Minute
+
+// minForecastSpan is the least amount of history, unless the window is
+// shorter, growth is extrapolated from; a burst of writes over a few
+// samples says little about when a filesystem fills up
+const minForecastSpan = 5 * time.Minute
+
+// now is a variable to make testing easy
+var now = time.Now
+
+// usageHistory keeps samples of used bytes and inodes of a filesystem
+// over a rolling window to estimate growth rate
+type usageHistory struct {
+	bytes misc.History
+	files misc.History
+}
+
+func newUsageHistory(window time.Duration) *usageHistory {
+	h := new(usageHistory)
+	h.setWindow(window)
+	return h
+}
+
+func (h *usageHistory) setWindow(window time.Duration) {
+	h.bytes.Window = window
+	h.files.Window = window
+}
+
+// add records a sample and drops samples which fell out of the window
+func (h *usageHistory) add(t time.Time, bytes, files float64) {
+	h.bytes.Add(t, bytes)
+	h.files.Add(t, files)
+}
+
+// growth returns estimated growth in used bytes/s and inodes/s using
+// a least squares linear fit or NaN if there is not enough history
+func (h *usageHistory) growth() (bytes float64, files float64) {
+	span := minForecastSpan
+	if h.bytes.Window < span {
+		span = h.bytes.Window
+	}
+	if h.bytes.Span() < span {
+		return math.NaN(), math.NaN()
+	}
+	return h.bytes.Growth(), h.files.Growth()
+}
+
+// untilFull returns seconds until free space is used up at the input
+// rate; +Inf if usage is not growing
+func untilFull(free, rate float64) float64 {
+	if math.IsNaN(rate) || math.IsNaN(free) {
+		return math.NaN()
+	}
+	if rate <= 0 {
+		return math.Inf(1)
+	}
+	return free / rate
+}
@@ -5,9 +5,12 @@ package fsstat
 
 import (
 	"bufio"
+	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -16,11 +19,39 @@ import (
 	"github.com/square/inspect/os/misc"
 )
 
+// to make testing easy
+var root = "/"
+
+// Filter selects filesystems to collect statistics for. Types and
+// mountpoints are patterns as understood by filepath.Match; a trailing
+// "/*" in a mountpoint pattern matches everything below it. Empty
+// include lists include everything not excluded.
+type Filter struct {
+	IncludeTypes       []string
+	ExcludeTypes       []string
+	IncludeMountpoints []string
+	ExcludeMountpoints []string
+}
+
+// DefaultFilter skips pseudo filesystems and runtime state but keeps
+// memory backed filesystems users write to, say /dev/shm
+var DefaultFilter = Filter{
+	ExcludeTypes: []string{"proc", "sysfs", "devpts", "devtmpfs", "none",
+		"sunrpc", "swap", "bind", "ignore", "binfmt_misc", "rpc_pipefs",
+		"cgroup", "cgroup2", "securityfs", "debugfs", "tracefs", "pstore",
+		"bpf", "mqueue", "hugetlbfs", "configfs", "autofs", "nsfs",
+		"squashfs", "fuse*"},
+	ExcludeMountpoints: []string{"/proc/*", "/sys/*", "/run", "/run/*",
+		"/var/lib/docker/*", "/var/lib/kubelet/*"},
+}
+
 // FSStat represents file system statistics for all filesystems found
 // on this OS
 type FSStat struct {
-	FS map[string]*PerFSStat
-	m  *metrics.MetricContext
+	FS     map[string]*PerFSStat
+	m      *metrics.MetricContext
+	filter Filter
+	window time.Duration
 }
 
 // New registers with metriccontext and collects filesystem stats every
@@ -29,6 +60,8 @@ func New(m *metrics.MetricContext, Step time.Duration) *FSStat {
 	s := new(FSStat)
 	s.FS = make(map[string]*PerFSStat, 0)
 	s.m = m
+	s.filter = DefaultFilter
+	s.window = DefaultForecastWindow
 	ticker := time.NewTicker(Step)
 	go func() {
 		for _ = range ticker.C {
@@ -38,10 +71,24 @@ func New(m *metrics.MetricContext, Step time.Duration) *FSStat {
 	return s
 }
 
-// Collect is run every step seconds to parse /etc/mstab
+// SetFilter sets filter used to select filesystems
+func (s *FSStat) SetFilter(f Filter) {
+	s.filter = f
+}
+
+// SetForecastWindow sets amount of history used to estimate how fast
+// filesystems fill up
+func (s *FSStat) SetForecastWindow(window time.Duration) {
+	s.window = window
+	for _, o := range s.FS {
+		o.history.setWindow(window)
+	}
+}
+
+// Collect is run every step seconds to parse /proc/self/mountinfo
 // and gather inode/disk usage metrics
 func (s *FSStat) Collect() {
-	file, err := os.Open("/etc/mtab")
+	file, err := os.Open(root + "proc/self/mountinfo")
 	defer file.Close()
 	if err != nil {
 		return
@@ -51,27 +98,41 @@ func (s *FSStat) Collect() {
 	for _, o := range s.FS {
 		o.IsMounted = false
 	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		f := strings.Split(scanner.Text(), " ")
-		// ignore few types of mounts
-		// man fstab
-		switch f[2] {
-		case "proc", "sysfs", "devpts", "none", "sunrpc", "swap", "bind", "ignore", "tmpfs", "binfmt_misc", "rpc_pipefs":
-			continue
-		}
-		if strings.Contains(f[2], "fuse") {
-			continue
-		}
-		if strings.Contains(f[3], "loop=") {
-			continue
+	// filter before dropping bind mounts so that a filesystem whose
+	// root is mounted somewhere excluded is still found where it
+	// is bind mounted
+	var mounts []*mount
+	for _, mnt := range parseMountinfo(file) {
+		if s.filter.match(mnt) {
+			mounts = append(mounts, mnt)
 		}
-		o, ok := s.FS[f[1]]
+	}
+	for _, mnt := range dedupMounts(mounts) {
+		o, ok := s.FS[mnt.mountpoint]
 		if !ok {
-			o = NewPerFSStat(s.m, f[1])
-			s.FS[f[1]] = o
+			o = NewPerFSStat(s.m, mnt.mountpoint)
+			if s.window > 0 {
+				o.history.setWindow(s.window)
+			}
+			s.FS[mnt.mountpoint] = o
 		}
 		o.IsMounted = true
+		o.Device = mnt.source
+		o.DevNum = mnt.devnum
+		o.Type = mnt.fstype
+		readOnly := mnt.readOnly()
+		if readOnly && o.seenReadWrite {
+			o.RemountedReadOnly = true
+		}
+		if !readOnly {
+			o.seenReadWrite = true
+			o.RemountedReadOnly = false
+		}
+		// errors=remount-ro leaves the mount rw but the superblock ro
+		if hasOption(mnt.options, "rw") && hasOption(mnt.superOptions, "ro") {
+			o.RemountedReadOnly = true
+		}
+		o.ReadOnly = readOnly
 		o.Collect()
 	}
 	// remove entries for mounts that no longer exist
@@ -106,19 +167,28 @@ func (s *FSStat) ByUsage() []*PerFSStat {
 // PerFSStat represents type for filesystem specific information
 // including associated metrics
 type PerFSStat struct {
-	m         *metrics.MetricContext
-	mp        string
-	IsMounted bool
-	Name      string
-	Bsize     *metrics.Gauge
-	Blocks    *metrics.Gauge
-	Bfree     *metrics.Gauge
-	Bavail    *metrics.Gauge
-	Files     *metrics.Gauge
-	Ffree     *metrics.Gauge
+	m                 *metrics.MetricContext
+	mp                string
+	IsMounted         bool
+	Name              string
+	Device            string // mount source, say /dev/mapper/vg0-root
+	DevNum            string // major:minor of the backing block device
+	Type              string
+	ReadOnly          bool
+	RemountedReadOnly bool // was read-write when first seen or has errors
+	seenReadWrite     bool
+	history           *usageHistory
+	Bsize             *metrics.Gauge
+	Blocks            *metrics.Gauge
+	Bfree             *metrics.Gauge
+	Bavail            *metrics.Gauge
+	Files             *metrics.Gauge
+	Ffree             *metrics.Gauge
 	// Computed stats
-	UsagePct     *metrics.Gauge
-	FileUsagePct *metrics.Gauge
+	UsagePct               *metrics.Gauge
+	FileUsagePct           *metrics.Gauge
+	SecondsUntilFull       *metrics.Gauge // at growth rate over forecast window
+	SecondsUntilInodesFull *metrics.Gauge
 }
 
 // NewPerFSStat registers with metriccontext for the particular filesystem
@@ -127,6 +197,7 @@ func NewPerFSStat(m *metrics.MetricContext, mp string) *PerFSStat {
 	fs.m = m
 	fs.mp = mp
 	fs.Name = mp
+	fs.history = newUsageHistory(DefaultForecastWindow)
 	misc.InitializeMetrics(fs, m, "fsstat."+mp, true)
 	return fs
 }
@@ -140,7 +211,7 @@ func (s *PerFSStat) Unregister() {
 func (s *PerFSStat) Collect() {
 	// call statfs and populate metrics
 	buf := new(syscall.Statfs_t)
-	err := syscall.Statfs(s.mp, buf)
+	err := syscall.Statfs(filepath.Join(root, s.mp), buf)
 	if err != nil {
 		return
 	}
@@ -153,6 +224,7 @@ func (s *PerFSStat) Collect() {
 	s.Ffree.Set(float64(buf.Ffree))
 	s.UsagePct.Set(s.Usage())
 	s.FileUsagePct.Set(s.FileUsage())
+	s.forecast()
 }
 
 // Usage returns filesystem block usage in percentage
@@ -168,3 +240,153 @@ func (s *PerFSStat) FileUsage() float64 {
 	free := s.Ffree.Get()
 	return ((total - free) / total) * 100
 }
+
+// Unexported functions
+
+// forecast records current usage and estimates time until the
+// filesystem runs out of blocks and inodes available to users
+func (s *PerFSStat) forecast() {
+	bsize := s.Bsize.Get()
+	used := (s.Blocks.Get() - s.Bfree.Get()) * bsize
+	usedFiles := s.Files.Get() - s.Ffree.Get()
+	s.history.add(now(), used, usedFiles)
+	rate, fileRate := s.history.growth()
+	s.SecondsUntilFull.Set(untilFull(s.Bavail.Get()*bsize, rate))
+	// filesystems with dynamic inode allocation report no inodes
+	if s.Files.Get() > 0 {
+		s.SecondsUntilInodesFull.Set(untilFull(s.Ffree.Get(), fileRate))
+	}
+}
+
+// mount represents a line of /proc/self/mountinfo:
+// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+type mount struct {
+	devnum       string
+	root         string // root of the mount within the filesystem
+	mountpoint   string
+	options      string // per mount options
+	fstype       string
+	source       string
+	superOptions string // per superblock options
+}
+
+func (m *mount) readOnly() bool {
+	return hasOption(m.options, "ro") || hasOption(m.superOptions, "ro")
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountinfo parses mountinfo format described in proc(5).
+// Later mounts on the same mountpoint hide earlier ones.
+func parseMountinfo(r io.Reader) []*mount {
+	var mounts []*mount
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		// optional fields are terminated by a single hyphen
+		sep := -1
+		for i := 6; i < len(f); i++ {
+			if f[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(f) < sep+4 {
+			continue
+		}
+		m := &mount{
+			devnum:       f[2],
+			root:         unescape(f[3]),
+			mountpoint:   unescape(f[4]),
+			options:      f[5],
+			fstype:       f[sep+1],
+			source:       unescape(f[sep+2]),
+			superOptions: f[sep+3],
+		}
+		if i, ok := index[m.mountpoint]; ok {
+			mounts[i] = m
+			continue
+		}
+		index[m.mountpoint] = len(mounts)
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+// dedupMounts drops bind mounts of a filesystem which is already
+// mounted elsewhere preferring mounts of the root of the filesystem
+func dedupMounts(mounts []*mount) []*mount {
+	best := make(map[string]*mount)
+	var order []string
+	for _, m := range mounts {
+		o, ok := best[m.devnum]
+		if !ok {
+			best[m.devnum] = m
+			order = append(order, m.devnum)
+			continue
+		}
+		if o.root != "/" && m.root == "/" {
+			best[m.devnum] = m
+		}
+	}
+	var v []*mount
+	for _, devnum := range order {
+		v = append(v, best[devnum])
+	}
+	return v
+}
+
+// unescape decodes octal escapes (\040 for space) used by the
+// kernel for whitespace and backslashes in mountinfo
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// match returns true if the mount passes the filter
+func (f *Filter) match(m *mount) bool {
+	if len(f.IncludeTypes) > 0 && !matchAny(f.IncludeTypes, m.fstype, false) {
+		return false
+	}
+	if matchAny(f.ExcludeTypes, m.fstype, false) {
+		return false
+	}
+	if len(f.IncludeMountpoints) > 0 && !matchAny(f.IncludeMountpoints, m.mountpoint, true) {
+		return false
+	}
+	return !matchAny(f.ExcludeMountpoints, m.mountpoint, true)
+}
+
+func matchAny(patterns []string, name string, isPath bool) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+		if isPath && strings.HasSuffix(p, "/*") &&
+			strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
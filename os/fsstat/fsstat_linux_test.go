@@ -0,0 +1,125 @@
+// Copyright (c) 2015 Square, Inc
+
+package fsstat
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestFSStat(t *testing.T) {
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := &FSStat{FS: make(map[string]*PerFSStat), m: m, filter: DefaultFilter}
+	s.Collect()
+	var names []string
+	for name := range s.FS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := "/,/data,/dev/shm,/mnt/my disk,/var/log"
+	if actual := strings.Join(names, ","); actual != expected {
+		t.Errorf("fsstat filesystems: %v expected: %v", actual, expected)
+	}
+	if actual := s.FS["/data"].DevNum; actual != "8:17" {
+		t.Errorf("fsstat devnum: %v expected: 8:17", actual)
+	}
+	if s.FS["/data"].ReadOnly || s.FS["/data"].RemountedReadOnly {
+		t.Errorf("fsstat expected /data to be read-write")
+	}
+	// superblock remounted read-only after errors
+	if !s.FS["/var/log"].RemountedReadOnly {
+		t.Errorf("fsstat expected /var/log to be remounted read-only")
+	}
+	root = "testdata/t1/"
+	s.Collect()
+	if !s.FS["/data"].ReadOnly || !s.FS["/data"].RemountedReadOnly {
+		t.Errorf("fsstat expected /data to be remounted read-only")
+	}
+}
+
+func TestFSStatFilter(t *testing.T) {
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := &FSStat{FS: make(map[string]*PerFSStat), m: m}
+	s.SetFilter(Filter{IncludeTypes: []string{"tmpfs"},
+		ExcludeMountpoints: []string{"/run/*"}})
+	s.Collect()
+	if len(s.FS) != 3 || s.FS["/run"] == nil || s.FS["/sys/fs/cgroup"] == nil {
+		t.Errorf("fsstat unexpected filesystems: %v", s.FS)
+	}
+}
+
+func TestFSStatFilterBindMount(t *testing.T) {
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := &FSStat{FS: make(map[string]*PerFSStat), m: m}
+	// root of the filesystem on sdb1 is excluded but a directory of it
+	// is bind mounted on /srv/data
+	s.SetFilter(Filter{IncludeTypes: []string{"xfs"},
+		ExcludeMountpoints: []string{"/data"}})
+	s.Collect()
+	if len(s.FS) != 1 || s.FS["/srv/data"] == nil {
+		t.Errorf("fsstat unexpected filesystems: %v", s.FS)
+	}
+}
+
+func TestFSStatForecast(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	fs := NewPerFSStat(m, "/var/log")
+	start := time.Unix(1400000000, 0)
+	defer func() { now = time.Now }()
+	// 4096 byte blocks, 10 blocks (40960 bytes) written every minute
+	fs.Bsize.Set(4096)
+	fs.Blocks.Set(100000)
+	fs.Files.Set(1000)
+	for i := 0; i < 10; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		now = func() time.Time { return ts }
+		fs.Bfree.Set(float64(50000 - 10*i))
+		fs.Bavail.Set(float64(45000 - 10*i))
+		fs.Ffree.Set(500)
+		fs.forecast()
+	}
+	// 44910 blocks left at 10 blocks a minute
+	expected := 44910.0 * 6
+	if actual := fs.SecondsUntilFull.Get(); math.Abs(actual-expected) > 1 {
+		t.Errorf("fsstat seconds until full: %v expected: %v", actual, expected)
+	}
+	if actual := fs.SecondsUntilInodesFull.Get(); !math.IsInf(actual, 1) {
+		t.Errorf("fsstat seconds until inodes full: %v expected: +Inf", actual)
+	}
+	// samples older than forecast window are dropped
+	fs.history.setWindow(5 * time.Minute)
+	now = func() time.Time { return start.Add(10 * time.Minute) }
+	fs.forecast()
+	if fs.history.bytes.Len() != 6 {
+		t.Errorf("fsstat history: %v samples expected: 6", fs.history.bytes.Len())
+	}
+}
+
+func TestFSStatForecastBurst(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	fs := NewPerFSStat(m, "/var/log")
+	start := time.Unix(1400000000, 0)
+	defer func() { now = time.Now }()
+	// a burst of writes sampled every 2 seconds is not extrapolated
+	fs.Bsize.Set(4096)
+	fs.Blocks.Set(100000)
+	for i := 0; i < 10; i++ {
+		ts := start.Add(time.Duration(2*i) * time.Second)
+		now = func() time.Time { return ts }
+		fs.Bfree.Set(float64(50000 - 1000*i))
+		fs.Bavail.Set(float64(45000 - 1000*i))
+		fs.forecast()
+	}
+	if actual := fs.SecondsUntilFull.Get(); !math.IsNaN(actual) {
+		t.Errorf("fsstat seconds until full after %v: %v expected: NaN",
+			fs.history.bytes.Span(), actual)
+	}
+}
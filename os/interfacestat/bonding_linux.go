@@ -0,0 +1,206 @@
+// Copyright (c) 2015 Square, Inc
+
+package interfacestat
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// BondStat represents state of a bonded interface as reported by
+// /proc/net/bonding/<bond>
+type BondStat struct {
+	Metrics   *BondStatMetrics
+	Name      string
+	Mode      string
+	MIIStatus string
+	Slaves    map[string]*BondSlaveStat
+	maxSlaves int // most slaves seen since collection started
+	m         *metrics.MetricContext
+}
+
+// BondStatMetrics represents statistics automatically initialized
+// per bonded interface
+type BondStatMetrics struct {
+	Slaves   *metrics.Gauge
+	SlavesUp *metrics.Gauge
+}
+
+// BondSlaveStat represents state of a single slave of a bond
+type BondSlaveStat struct {
+	Metrics   *BondSlaveStatMetrics
+	Name      string
+	MIIStatus string
+	Speed     string
+	Duplex    string
+	prefix    string
+}
+
+// BondSlaveStatMetrics represents statistics automatically initialized
+// per slave of a bond
+type BondSlaveStatMetrics struct {
+	Up           *metrics.Gauge
+	LinkFailures *metrics.Counter
+}
+
+// NewBondStat initializes and registers metrics with metriccontext
+// for a bonded interface
+func NewBondStat(m *metrics.MetricContext, bond string) *BondStat {
+	s := new(BondStat)
+	s.Name = bond
+	s.m = m
+	s.Slaves = make(map[string]*BondSlaveStat)
+	s.Metrics = new(BondStatMetrics)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s.Metrics, m, "interfacestat."+bond+".bond", true)
+	return s
+}
+
+// Degraded returns true if the bond is down, has slaves which are
+// down or has lost slaves since collection started
+func (s *BondStat) Degraded() bool {
+	if s.MIIStatus != "up" {
+		return true
+	}
+	return s.Metrics.SlavesUp.Get() < float64(s.maxSlaves)
+}
+
+// SlavesDown returns number of slaves which are down or were removed
+// from the bond since collection started
+func (s *BondStat) SlavesDown() int {
+	return s.maxSlaves - int(s.Metrics.SlavesUp.Get())
+}
+
+// LinkFailures returns number of link failures/s across all slaves
+func (s *BondStat) LinkFailures() float64 {
+	var total float64
+	for _, slave := range s.Slaves {
+		total += slave.Metrics.LinkFailures.ComputeRate()
+	}
+	return total
+}
+
+// Unexported functions
+
+// collectBonds reads /proc/net/bonding/* and removes bonds which
+// went away
+func (s *InterfaceStat) collectBonds() {
+	paths, _ := filepath.Glob(root + "proc/net/bonding/*")
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		bond := filepath.Base(path)
+		o, ok := s.Bonds[bond]
+		if !ok {
+			o = NewBondStat(s.m, bond)
+			s.Bonds[bond] = o
+		}
+		if o.collect(path) {
+			seen[bond] = true
+		}
+	}
+	for bond, o := range s.Bonds {
+		if !seen[bond] {
+			o.unregister()
+			delete(s.Bonds, bond)
+		}
+	}
+}
+
+// collect parses a /proc/net/bonding/<bond> file which looks like:
+//
+//	Bonding Mode: IEEE 802.3ad Dynamic link aggregation
+//	MII Status: up
+//	...
+//	Slave Interface: eth0
+//	MII Status: up
+//	Speed: 10000 Mbps
+//	Duplex: full
+//	Link Failure Count: 0
+func (s *BondStat) collect(path string) bool {
+	file, err := os.Open(path)
+	defer file.Close()
+	if err != nil {
+		return false
+	}
+	var slave *BondSlaveStat
+	seen := make(map[string]bool)
+	up := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.SplitN(scanner.Text(), ":", 2)
+		if len(f) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(f[0])
+		value := strings.TrimSpace(f[1])
+		switch key {
+		case "Bonding Mode":
+			s.Mode = value
+		case "Slave Interface":
+			var ok bool
+			slave, ok = s.Slaves[value]
+			if !ok {
+				slave = s.newSlave(value)
+				s.Slaves[value] = slave
+			}
+			seen[value] = true
+		case "MII Status":
+			if slave == nil {
+				s.MIIStatus = value
+				continue
+			}
+			slave.MIIStatus = value
+			if value == "up" {
+				slave.Metrics.Up.Set(1)
+				up++
+			} else {
+				slave.Metrics.Up.Set(0)
+			}
+		case "Speed":
+			if slave != nil {
+				slave.Speed = value
+			}
+		case "Duplex":
+			if slave != nil {
+				slave.Duplex = value
+			}
+		case "Link Failure Count":
+			if slave != nil {
+				slave.Metrics.LinkFailures.Set(misc.ParseUint(value))
+			}
+		}
+	}
+	for name, slave := range s.Slaves {
+		if !seen[name] {
+			misc.UnregisterMetrics(slave.Metrics, s.m, slave.prefix)
+			delete(s.Slaves, name)
+		}
+	}
+	if len(s.Slaves) > s.maxSlaves {
+		s.maxSlaves = len(s.Slaves)
+	}
+	s.Metrics.Slaves.Set(float64(len(s.Slaves)))
+	s.Metrics.SlavesUp.Set(float64(up))
+	return true
+}
+
+func (s *BondStat) newSlave(name string) *BondSlaveStat {
+	slave := new(BondSlaveStat)
+	slave.Name = name
+	slave.prefix = "interfacestat." + s.Name + ".slave." + name
+	slave.Metrics = new(BondSlaveStatMetrics)
+	misc.InitializeMetrics(slave.Metrics, s.m, slave.prefix, true)
+	return slave
+}
+
+func (s *BondStat) unregister() {
+	for _, slave := range s.Slaves {
+		misc.UnregisterMetrics(slave.Metrics, s.m, slave.prefix)
+	}
+	misc.UnregisterMetrics(s.Metrics, s.m, "interfacestat."+s.Name+".bond")
+}
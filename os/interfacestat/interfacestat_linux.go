@@ -6,6 +6,7 @@ package interfacestat
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
 	"sort"
@@ -22,6 +23,7 @@ var root = "/"
 // InterfaceStat represents statistics about all interfaces
 type InterfaceStat struct {
 	Interfaces map[string]*PerInterfaceStat
+	Bonds      map[string]*BondStat
 	m          *metrics.MetricContext
 }
 
@@ -31,6 +33,7 @@ type InterfaceStat struct {
 func New(m *metrics.MetricContext, Step time.Duration) *InterfaceStat {
 	s := new(InterfaceStat)
 	s.Interfaces = make(map[string]*PerInterfaceStat, 4)
+	s.Bonds = make(map[string]*BondStat)
 	s.m = m
 
 	ticker := time.NewTicker(Step)
@@ -44,7 +47,8 @@ func New(m *metrics.MetricContext, Step time.Duration) *InterfaceStat {
 }
 
 // Collect reads /proc/net/dev to gather statistics for interfaces.
-// Collect reads /sysfs to figure out interface capabilities.
+// Collect reads /sysfs to figure out interface capabilities and link state.
+// Collect reads /proc/net/bonding for state of bonded interfaces.
 // Collect is generally called directly when the package is initialized.
 func (s *InterfaceStat) Collect() {
 	file, err := os.Open(root + "proc/net/dev")
@@ -93,11 +97,18 @@ func (s *InterfaceStat) Collect() {
 		d.TXframe.Set(tx[5])
 		d.TXcompressed.Set(tx[6])
 		d.TXmulticast.Set(tx[7])
-		speed := misc.ReadUintFromFile(root + "sys/class/net/" + dev + "/speed")
+		sysfs := root + "sys/class/net/" + dev + "/"
+		speed := misc.ReadUintFromFile(sysfs + "speed")
 		if speed > 0 {
 			d.Speed.Set(float64(speed))
 		}
+		d.MTU.Set(float64(misc.ReadUintFromFile(sysfs + "mtu")))
+		d.Carrier.Set(float64(misc.ReadUintFromFile(sysfs + "carrier")))
+		d.CarrierChanges.Set(misc.ReadUintFromFile(sysfs + "carrier_changes"))
+		o.OperState = readString(sysfs + "operstate")
+		o.Duplex = readString(sysfs + "duplex")
 	}
+	s.collectBonds()
 }
 
 // byUsage represents list of interfaces sorted by Usage
@@ -124,31 +135,36 @@ func (s *InterfaceStat) ByUsage() []*PerInterfaceStat {
 
 // PerInterfaceStat represents statistics Collected for a single interface
 type PerInterfaceStat struct {
-	Metrics *PerInterfaceStatMetrics
-	m       *metrics.MetricContext
-	Name    string
+	Metrics   *PerInterfaceStatMetrics
+	m         *metrics.MetricContext
+	Name      string
+	OperState string // up, down, dormant, unknown...
+	Duplex    string // full, half or unknown
 }
 
 // PerInterfaceStatMetrics represents statistics automatically initialized
 // per interface
 type PerInterfaceStatMetrics struct {
-	RXbytes      *metrics.Counter
-	RXpackets    *metrics.Counter
-	RXerrs       *metrics.Counter
-	RXdrop       *metrics.Counter
-	RXfifo       *metrics.Counter
-	RXframe      *metrics.Counter
-	RXcompressed *metrics.Counter
-	RXmulticast  *metrics.Counter
-	TXbytes      *metrics.Counter
-	TXpackets    *metrics.Counter
-	TXerrs       *metrics.Counter
-	TXdrop       *metrics.Counter
-	TXfifo       *metrics.Counter
-	TXframe      *metrics.Counter
-	TXcompressed *metrics.Counter
-	TXmulticast  *metrics.Counter
-	Speed        *metrics.Gauge
+	RXbytes        *metrics.Counter
+	RXpackets      *metrics.Counter
+	RXerrs         *metrics.Counter
+	RXdrop         *metrics.Counter
+	RXfifo         *metrics.Counter
+	RXframe        *metrics.Counter
+	RXcompressed   *metrics.Counter
+	RXmulticast    *metrics.Counter
+	TXbytes        *metrics.Counter
+	TXpackets      *metrics.Counter
+	TXerrs         *metrics.Counter
+	TXdrop         *metrics.Counter
+	TXfifo         *metrics.Counter
+	TXframe        *metrics.Counter
+	TXcompressed   *metrics.Counter
+	TXmulticast    *metrics.Counter
+	Speed          *metrics.Gauge
+	MTU            *metrics.Gauge
+	Carrier        *metrics.Gauge
+	CarrierChanges *metrics.Counter
 }
 
 // NewPerInterfaceStat initializes and registers metrics with metriccontext
@@ -191,3 +207,73 @@ func (s *PerInterfaceStat) RXBandwidthUsage() float64 {
 func (s *PerInterfaceStat) TXBandwidthUsage() float64 {
 	return (s.TXBandwidth() / s.Speed()) * 100
 }
+
+// RXPackets returns packets/s received
+func (s *PerInterfaceStat) RXPackets() float64 {
+	return s.Metrics.RXpackets.ComputeRate()
+}
+
+// TXPackets returns packets/s transmitted
+func (s *PerInterfaceStat) TXPackets() float64 {
+	return s.Metrics.TXpackets.ComputeRate()
+}
+
+// RXErrors returns receive errors/s including framing errors
+func (s *PerInterfaceStat) RXErrors() float64 {
+	o := s.Metrics
+	return o.RXerrs.ComputeRate() + o.RXframe.ComputeRate()
+}
+
+// TXErrors returns transmit errors/s
+func (s *PerInterfaceStat) TXErrors() float64 {
+	return s.Metrics.TXerrs.ComputeRate()
+}
+
+// RXDrops returns packets/s dropped on receive either by the kernel
+// or because the NIC ring buffer overflowed
+func (s *PerInterfaceStat) RXDrops() float64 {
+	o := s.Metrics
+	return o.RXdrop.ComputeRate() + o.RXfifo.ComputeRate()
+}
+
+// TXDrops returns packets/s dropped on transmit
+func (s *PerInterfaceStat) TXDrops() float64 {
+	o := s.Metrics
+	return o.TXdrop.ComputeRate() + o.TXfifo.ComputeRate()
+}
+
+// RXDropUsage returns received packets dropped or in error as
+// percentage of all received packets
+func (s *PerInterfaceStat) RXDropUsage() float64 {
+	bad := s.RXDrops() + s.RXErrors()
+	return (bad / (s.RXPackets() + bad)) * 100
+}
+
+// TXDropUsage returns transmitted packets dropped or in error as
+// percentage of all transmitted packets
+func (s *PerInterfaceStat) TXDropUsage() float64 {
+	bad := s.TXDrops() + s.TXErrors()
+	return (bad / (s.TXPackets() + bad)) * 100
+}
+
+// CarrierFlaps returns number of times/s carrier of the interface
+// went up or down
+func (s *PerInterfaceStat) CarrierFlaps() float64 {
+	return s.Metrics.CarrierChanges.ComputeRate()
+}
+
+// HalfDuplex returns true if the link negotiated half duplex
+func (s *PerInterfaceStat) HalfDuplex() bool {
+	return s.Duplex == "half"
+}
+
+// Unexported functions
+
+// readString returns trimmed contents of a sysfs attribute
+func readString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -22,4 +22,23 @@ func TestInterfaceStat(t *testing.T) {
 	if actual != expected {
 		t.Errorf("interfacestat txbytes: %v expected: %v", actual, expected)
 	}
+	if actual := istat.Interfaces["eth0"].Metrics.RXdrop.Get(); actual != 7 {
+		t.Errorf("interfacestat rxdrop: %v expected: 7", actual)
+	}
+	if !istat.Interfaces["eth0"].HalfDuplex() {
+		t.Errorf("interfacestat expected eth0 to be half duplex")
+	}
+	if actual := istat.Interfaces["eth0"].Metrics.CarrierChanges.Get(); actual != 6 {
+		t.Errorf("interfacestat carrier changes: %v expected: 6", actual)
+	}
+	bond, ok := istat.Bonds["bond0"]
+	if !ok {
+		t.Fatalf("interfacestat expected bond0")
+	}
+	if !bond.Degraded() || bond.SlavesDown() != 1 {
+		t.Errorf("interfacestat expected bond0 to be degraded with 1 slave down")
+	}
+	if actual := bond.Slaves["eth1"].Metrics.LinkFailures.Get(); actual != 1 {
+		t.Errorf("interfacestat link failures: %v expected: 1", actual)
+	}
 }
@@ -0,0 +1,301 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package irqstat implements metrics collection related to hardware
+// interrupts and softirqs and their distribution across CPUs
+package irqstat
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// now is a variable to make testing easy
+var now = time.Now
+
+// IRQStat represents interrupts by source and by CPU as reported by
+// /proc/interrupts and /proc/softirqs
+type IRQStat struct {
+	IRQs     map[string]*PerIRQStat // numbered device interrupts
+	Softirqs map[string]*PerIRQStat // NET_RX, TIMER...
+	CPUs     []*PerCPUIRQStat
+	m        *metrics.MetricContext
+	last     time.Time
+}
+
+// New registers with metriccontext and collects interrupt statistics
+// every Step
+func New(m *metrics.MetricContext, Step time.Duration) *IRQStat {
+	s := new(IRQStat)
+	s.IRQs = make(map[string]*PerIRQStat)
+	s.Softirqs = make(map[string]*PerIRQStat)
+	s.m = m
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect parses /proc/interrupts and /proc/softirqs and computes
+// per-CPU rates since the last collection
+func (s *IRQStat) Collect() {
+	t := now()
+	elapsed := t.Sub(s.last).Seconds()
+	if s.last.IsZero() {
+		elapsed = 0
+	}
+	s.last = t
+	ncpu := s.parse(root+"proc/interrupts", s.IRQs, true, elapsed)
+	s.parse(root+"proc/softirqs", s.Softirqs, false, elapsed)
+	if ncpu == 0 {
+		return
+	}
+	// per-CPU totals
+	if len(s.CPUs) != ncpu {
+		for _, c := range s.CPUs {
+			c.unregister(s.m)
+		}
+		s.CPUs = make([]*PerCPUIRQStat, ncpu)
+		for i := range s.CPUs {
+			s.CPUs[i] = newPerCPUIRQStat(s.m, i)
+		}
+	}
+	for i, c := range s.CPUs {
+		var hard, soft uint64
+		c.rate = 0
+		for _, o := range s.IRQs {
+			if i < len(o.counts) {
+				hard += o.counts[i]
+				c.rate += o.rates[i]
+			}
+		}
+		for _, o := range s.Softirqs {
+			if i < len(o.counts) {
+				soft += o.counts[i]
+			}
+		}
+		c.Interrupts.Set(hard)
+		c.Softirqs.Set(soft)
+		if o, ok := s.Softirqs["NET_RX"]; ok && i < len(o.counts) {
+			c.NetRX.Set(o.counts[i])
+		}
+		if o, ok := s.Softirqs["NET_TX"]; ok && i < len(o.counts) {
+			c.NetTX.Set(o.counts[i])
+		}
+		if o, ok := s.Softirqs["BLOCK"]; ok && i < len(o.counts) {
+			c.Block.Set(o.counts[i])
+		}
+	}
+}
+
+// Imbalance returns how unevenly device interrupts are spread across
+// CPUs: 0 if evenly spread, 1 if a single CPU handles all of them,
+// along with index of the busiest CPU
+func (s *IRQStat) Imbalance() (float64, int) {
+	rates := make([]float64, len(s.CPUs))
+	for i, c := range s.CPUs {
+		rates[i] = c.rate
+	}
+	return imbalance(rates)
+}
+
+// Rate returns device interrupts/s across all CPUs
+func (s *IRQStat) Rate() float64 {
+	var total float64
+	for _, c := range s.CPUs {
+		total += c.rate
+	}
+	return total
+}
+
+// byRate represents list of interrupts sorted by rate
+type byRate []*PerIRQStat
+
+func (a byRate) Len() int           { return len(a) }
+func (a byRate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byRate) Less(i, j int) bool { return a[i].Rate() > a[j].Rate() }
+
+// ByRate returns an slice of device interrupts sorted by interrupts/s
+func (s *IRQStat) ByRate() []*PerIRQStat {
+	var v []*PerIRQStat
+	for _, o := range s.IRQs {
+		v = append(v, o)
+	}
+	sort.Sort(byRate(v))
+	return v
+}
+
+// PerIRQStat represents counts of an interrupt on every CPU
+type PerIRQStat struct {
+	Count  *metrics.Counter // total across all CPUs
+	Name   string           // IRQ number or softirq name
+	Device string           // say eth0-TxRx-0
+	counts []uint64
+	rates  []float64
+}
+
+// Rate returns interrupts/s across all CPUs
+func (s *PerIRQStat) Rate() float64 {
+	var total float64
+	for _, r := range s.rates {
+		total += r
+	}
+	return total
+}
+
+// CPURates returns interrupts/s on every CPU
+func (s *PerIRQStat) CPURates() []float64 {
+	return s.rates
+}
+
+// Imbalance returns how unevenly the interrupt is spread across CPUs
+// (see IRQStat.Imbalance) along with index of the busiest CPU
+func (s *PerIRQStat) Imbalance() (float64, int) {
+	return imbalance(s.rates)
+}
+
+// PerCPUIRQStat represents interrupts handled by a CPU
+type PerCPUIRQStat struct {
+	Interrupts *metrics.Counter // device interrupts
+	Softirqs   *metrics.Counter
+	NetRX      *metrics.Counter
+	NetTX      *metrics.Counter
+	Block      *metrics.Counter
+	Name       string
+	rate       float64
+}
+
+// Rate returns device interrupts/s handled by the CPU
+func (s *PerCPUIRQStat) Rate() float64 {
+	return s.rate
+}
+
+// Unexported functions
+
+func newPerCPUIRQStat(m *metrics.MetricContext, cpu int) *PerCPUIRQStat {
+	s := new(PerCPUIRQStat)
+	s.Name = "cpu" + strconv.Itoa(cpu)
+	misc.InitializeMetrics(s, m, "irqstat."+s.Name, true)
+	return s
+}
+
+func (s *PerCPUIRQStat) unregister(m *metrics.MetricContext) {
+	misc.UnregisterMetrics(s, m, "irqstat."+s.Name)
+}
+
+// parse reads /proc/interrupts or /proc/softirqs into stats and
+// returns number of CPUs found. Only numbered interrupts are kept
+// from /proc/interrupts if numbered is set.
+func (s *IRQStat) parse(path string, stats map[string]*PerIRQStat,
+	numbered bool, elapsed float64) int {
+	file, err := os.Open(path)
+	defer file.Close()
+	if err != nil {
+		return 0
+	}
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0
+	}
+	prefix := "irqstat.softirq."
+	if numbered {
+		prefix = "irqstat.irq."
+	}
+	// header: CPU0 CPU1 ...
+	ncpu := len(strings.Fields(scanner.Text()))
+	seen := make(map[string]bool)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		if numbered && misc.ParseUint(name) == 0 && name != "0" {
+			continue
+		}
+		f := strings.Fields(line[i+1:])
+		if len(f) < ncpu {
+			continue
+		}
+		o, ok := stats[name]
+		if !ok {
+			o = new(PerIRQStat)
+			o.Name = name
+			o.Count = metrics.NewCounter()
+			s.m.Register(o.Count, prefix+name+".Count")
+			stats[name] = o
+		}
+		seen[name] = true
+		// IO-APIC 2-edge timer or PCI-MSI 524288-edge eth0-TxRx-0
+		desc := f[ncpu:]
+		switch {
+		case len(desc) > 2:
+			o.Device = strings.Join(desc[2:], " ")
+		case len(desc) > 0:
+			o.Device = desc[len(desc)-1]
+		}
+		// no rates for new interrupts or if CPUs came or went
+		el := elapsed
+		if !ok || len(o.counts) != ncpu {
+			o.counts = make([]uint64, ncpu)
+			o.rates = make([]float64, ncpu)
+			el = 0
+		}
+		var total uint64
+		for c := 0; c < ncpu; c++ {
+			v := misc.ParseUint(f[c])
+			if el > 0 && v >= o.counts[c] {
+				o.rates[c] = float64(v-o.counts[c]) / el
+			} else {
+				o.rates[c] = 0
+			}
+			o.counts[c] = v
+			total += v
+		}
+		o.Count.Set(total)
+	}
+	for name, o := range stats {
+		if !seen[name] {
+			s.m.Unregister(o.Count, prefix+name+".Count")
+			delete(stats, name)
+		}
+	}
+	return ncpu
+}
+
+// imbalance scales share of the busiest CPU so that an even spread
+// is 0 and a single CPU handling everything is 1
+func imbalance(rates []float64) (float64, int) {
+	var total, max float64
+	busiest := 0
+	for i, r := range rates {
+		total += r
+		if r > max {
+			max = r
+			busiest = i
+		}
+	}
+	n := float64(len(rates))
+	if total == 0 || n < 2 {
+		return math.NaN(), busiest
+	}
+	fair := 1 / n
+	return (max/total - fair) / (1 - fair), busiest
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2015 Square, Inc
+
+package irqstat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestIRQStat(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return start }
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := &IRQStat{IRQs: make(map[string]*PerIRQStat),
+		Softirqs: make(map[string]*PerIRQStat), m: m}
+	s.Collect()
+	now = func() time.Time { return start.Add(10 * time.Second) }
+	root = "testdata/t1/"
+	s.Collect()
+	if len(s.IRQs) != 5 || len(s.CPUs) != 4 {
+		t.Fatalf("irqstat: %v irqs and %v cpus expected: 5 and 4", len(s.IRQs), len(s.CPUs))
+	}
+	irqs := s.ByRate()
+	if irqs[0].Name != "25" || irqs[0].Device != "eth0-TxRx-1" || irqs[0].Rate() != 3000 {
+		t.Errorf("irqstat unexpected hottest irq: %v %v %v",
+			irqs[0].Name, irqs[0].Device, irqs[0].Rate())
+	}
+	if score, cpu := irqs[0].Imbalance(); score != 1 || cpu != 0 {
+		t.Errorf("irqstat imbalance of irq 25: %v on cpu%v expected: 1 on cpu0", score, cpu)
+	}
+	if score, _ := irqs[2].Imbalance(); score != 0 {
+		t.Errorf("irqstat imbalance of irq 26: %v expected: 0", score)
+	}
+	// 5000/s device interrupts on cpu0, 100/s on others
+	score, cpu := s.Imbalance()
+	if expected := (5100.0/5400.0 - 0.25) / 0.75; math.Abs(score-expected) > 0.0001 || cpu != 0 {
+		t.Errorf("irqstat imbalance: %v on cpu%v expected: %v on cpu0", score, cpu, expected)
+	}
+	if actual := s.CPUs[0].NetRX.Get(); actual != 540000 {
+		t.Errorf("irqstat NET_RX on cpu0: %v expected: 540000", actual)
+	}
+}
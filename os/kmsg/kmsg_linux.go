@@ -0,0 +1,254 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package kmsg implements scanning of the kernel log for messages
+// pointing at hardware and kernel problems
+package kmsg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/square/inspect/conf"
+	"github.com/square/inspect/metrics"
+)
+
+// to make testing easy
+var root = "/"
+
+// now is a variable to make testing easy
+var now = time.Now
+
+// DefaultMaxEvents is the number of most recent matching messages kept
+const DefaultMaxEvents = 100
+
+// Rule classifies kernel messages matching Pattern as Class. The first
+// submatch, if any, is kept as subject of the event (say OOM victim
+// or device name).
+type Rule struct {
+	Class   string
+	Pattern *regexp.Regexp
+}
+
+// DefaultRules classify common signs of hardware and kernel problems
+var DefaultRules = []Rule{
+	{"hung_task", regexp.MustCompile(`task (\S+):\d+ blocked for more than \d+ seconds`)},
+	{"oom_kill", regexp.MustCompile(`(?:Out of memory|Memory cgroup out of memory): Kill(?:ed)? process \d+ \(([^)]+)\)`)},
+	{"io_error", regexp.MustCompile(`(?:I/O error,? dev (\w+)|Buffer I/O error on dev(?:ice)? (\w+))`)},
+	{"fs_error", regexp.MustCompile(`(?:EXT4-fs error \(device (\w+)\)|XFS \((\w+)\): (?:Corruption|metadata I/O error|Filesystem has been shut down)|EXT4-fs \((\w+)\): Remounting filesystem read-only)`)},
+	{"segfault", regexp.MustCompile(`(\S+)\[\d+\]: segfault at`)},
+	{"link_down", regexp.MustCompile(`(\w+):? (?:NIC )?[Ll]ink (?:is )?[Dd]own`)},
+	{"mce", regexp.MustCompile(`(?:mce: \[Hardware Error\]|Machine check events logged|EDAC .*(?:CE|UE) )`)},
+}
+
+// Event represents a kernel message which matched a rule
+type Event struct {
+	Class     string    `json:"class"`
+	Subject   string    `json:"subject,omitempty"`
+	Priority  int       `json:"priority"`  // 0 (emerg) to 7 (debug)
+	Timestamp float64   `json:"timestamp"` // seconds since boot
+	Time      time.Time `json:"time"`      // when inspect read the message
+	Message   string    `json:"message"`
+}
+
+// KmsgStat represents counts of kernel messages by class and the most
+// recent matching messages
+type KmsgStat struct {
+	Classes   map[string]*metrics.Counter
+	rules     []Rule
+	events    []Event
+	maxEvents int
+	mu        sync.Mutex
+	m         *metrics.MetricContext
+}
+
+// New registers with metriccontext and tails /dev/kmsg for new
+// messages. Reading /dev/kmsg usually requires root.
+func New(m *metrics.MetricContext) *KmsgStat {
+	s := newKmsgStat(m)
+	file, err := os.Open(root + "dev/kmsg")
+	if err != nil {
+		return s
+	}
+	// only messages logged from now on
+	file.Seek(0, io.SeekEnd)
+	go func() {
+		defer file.Close()
+		s.Scan(file)
+	}()
+	return s
+}
+
+// SetRules replaces rules used to classify messages
+func (s *KmsgStat) SetRules(rules []Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	s.registerClasses()
+}
+
+// SetMaxEvents sets number of most recent matching messages kept
+func (s *KmsgStat) SetMaxEvents(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxEvents = n
+	if len(s.events) > n {
+		s.events = s.events[len(s.events)-n:]
+	}
+}
+
+// Scan reads kernel messages in /dev/kmsg format until r returns an
+// error other than messages being overwritten before they were read
+func (s *KmsgStat) Scan(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			s.process(strings.TrimRight(line, "\n"))
+		}
+		if err != nil {
+			if errors.Is(err, syscall.EPIPE) {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// Events returns matching messages oldest first
+func (s *KmsgStat) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Recent returns matching messages read within the last d
+func (s *KmsgStat) Recent(d time.Duration) []Event {
+	cutoff := now().Add(-d)
+	var v []Event
+	for _, e := range s.Events() {
+		if !e.Time.Before(cutoff) {
+			v = append(v, e)
+		}
+	}
+	return v
+}
+
+// MarshalJSON returns counts by class and most recent matching messages
+func (s *KmsgStat) MarshalJSON() ([]byte, error) {
+	counts := make(map[string]uint64)
+	s.mu.Lock()
+	for class, c := range s.Classes {
+		counts[class] = c.Get()
+	}
+	s.mu.Unlock()
+	events := s.Events()
+	if events == nil {
+		events = []Event{}
+	}
+	return json.Marshal(struct {
+		Counts map[string]uint64 `json:"counts"`
+		Events []Event           `json:"events"`
+	}{counts, events})
+}
+
+// ReadRules reads rules from the [kmsg] section of a configuration
+// file with one "class = pattern" option per rule
+func ReadRules(path string) ([]Rule, error) {
+	c, err := conf.ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := c.GetOptions("kmsg")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(classes)
+	var rules []Rule
+	for _, class := range classes {
+		pattern, err := c.GetRawString("kmsg", class)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, Rule{class, re})
+	}
+	return rules, nil
+}
+
+// Unexported functions
+
+func newKmsgStat(m *metrics.MetricContext) *KmsgStat {
+	s := new(KmsgStat)
+	s.m = m
+	s.Classes = make(map[string]*metrics.Counter)
+	s.rules = DefaultRules
+	s.maxEvents = DefaultMaxEvents
+	s.registerClasses()
+	return s
+}
+
+// registerClasses registers a counter for every class in rules
+func (s *KmsgStat) registerClasses() {
+	for _, rule := range s.rules {
+		if _, ok := s.Classes[rule.Class]; !ok {
+			c := metrics.NewCounter()
+			s.m.Register(c, "kmsg."+rule.Class+".Count")
+			s.Classes[rule.Class] = c
+		}
+	}
+}
+
+// process parses a record like "3,1234,5140900,-;message" and keeps
+// it if it matches a rule. Continuation lines (" KEY=value") are ignored.
+func (s *KmsgStat) process(line string) {
+	i := strings.Index(line, ";")
+	if i < 0 || strings.HasPrefix(line, " ") {
+		return
+	}
+	prefix := strings.Split(line[:i], ",")
+	message := line[i+1:]
+	if len(prefix) < 3 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rule := range s.rules {
+		m := rule.Pattern.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		e := Event{Class: rule.Class, Time: now(), Message: message}
+		// alternatives in a pattern leave unused submatches empty
+		for _, sub := range m[1:] {
+			if sub != "" {
+				e.Subject = sub
+				break
+			}
+		}
+		if pri, err := strconv.Atoi(prefix[0]); err == nil {
+			e.Priority = pri & 7
+		}
+		if usec, err := strconv.ParseUint(prefix[2], 10, 64); err == nil {
+			e.Timestamp = float64(usec) / 1e6
+		}
+		s.Classes[rule.Class].Add(1)
+		s.events = append(s.events, e)
+		if len(s.events) > s.maxEvents {
+			s.events = s.events[len(s.events)-s.maxEvents:]
+		}
+		return
+	}
+}
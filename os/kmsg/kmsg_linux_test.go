@@ -0,0 +1,100 @@
+// Copyright (c) 2015 Square, Inc
+
+package kmsg
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func scan(t *testing.T, s *KmsgStat) {
+	file, err := os.Open("testdata/kmsg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := s.Scan(file); err != io.EOF {
+		t.Fatalf("kmsg scan: %v expected: EOF", err)
+	}
+}
+
+func TestKmsgStat(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return start }
+	m := metrics.NewMetricContext("system")
+	s := newKmsgStat(m)
+	scan(t, s)
+	expected := map[string]uint64{"hung_task": 1, "oom_kill": 2, "io_error": 1,
+		"fs_error": 1, "segfault": 1, "link_down": 1, "mce": 1}
+	for class, count := range expected {
+		if actual := s.Classes[class].Get(); actual != count {
+			t.Errorf("kmsg %s: %v expected: %v", class, actual, count)
+		}
+	}
+	events := s.Events()
+	if len(events) != 8 {
+		t.Fatalf("kmsg events: %v expected: 8", len(events))
+	}
+	subjects := []string{"kworker/u16:2", "java", "sdb", "sda1", "nginx", "eth0", "", "python3"}
+	for i, subject := range subjects {
+		if events[i].Subject != subject {
+			t.Errorf("kmsg event %d (%s) subject: %q expected: %q",
+				i, events[i].Class, events[i].Subject, subject)
+		}
+	}
+	if e := events[0]; e.Priority != 3 || e.Timestamp != 7.2001 {
+		t.Errorf("kmsg event priority/timestamp: %v/%v expected: 3/7.2001",
+			e.Priority, e.Timestamp)
+	}
+	s.SetMaxEvents(3)
+	if events := s.Events(); len(events) != 3 || events[2].Subject != "python3" {
+		t.Errorf("kmsg events after trim: %v", events)
+	}
+	now = func() time.Time { return start.Add(time.Hour) }
+	if recent := s.Recent(time.Minute); len(recent) != 0 {
+		t.Errorf("kmsg recent events: %v expected: none", len(recent))
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Counts map[string]uint64
+		Events []Event
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Counts["oom_kill"] != 2 || len(v.Events) != 3 {
+		t.Errorf("kmsg json: %s", b)
+	}
+}
+
+func TestKmsgRules(t *testing.T) {
+	rules, err := ReadRules("testdata/rules.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Class != "link_up" || rules[1].Class != "oom_kill" {
+		t.Fatalf("kmsg rules: %v", rules)
+	}
+	m := metrics.NewMetricContext("system")
+	s := newKmsgStat(m)
+	s.SetRules(rules)
+	scan(t, s)
+	if actual := s.Classes["link_up"].Get(); actual != 1 {
+		t.Errorf("kmsg link_up: %v expected: 1", actual)
+	}
+	if actual := s.Classes["oom_kill"].Get(); actual != 2 {
+		t.Errorf("kmsg oom_kill: %v expected: 2", actual)
+	}
+	if actual := s.Classes["hung_task"].Get(); actual != 0 {
+		t.Errorf("kmsg hung_task with custom rules: %v expected: 0", actual)
+	}
+}
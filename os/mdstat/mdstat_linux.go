@@ -0,0 +1,265 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package mdstat implements metrics collection related to software
+// RAID (md) arrays
+package mdstat
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// stallTimeout is how long a resync/recovery may make no progress
+// before it is considered stalled
+const stallTimeout = time.Minute
+
+// now is a variable to make testing easy
+var now = time.Now
+
+// MDStat represents statistics for all md arrays found on this OS
+type MDStat struct {
+	Arrays map[string]*PerArrayStat
+	m      *metrics.MetricContext
+}
+
+// New registers with metriccontext and collects md array statistics
+// every Step
+func New(m *metrics.MetricContext, Step time.Duration) *MDStat {
+	s := new(MDStat)
+	s.Arrays = make(map[string]*PerArrayStat)
+	s.m = m
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// regular expressions for /proc/mdstat
+var (
+	// md1 : active raid1 sdb2[1](F) sda2[0]
+	mdArrayRe = regexp.MustCompile(`^(md\S+)\s*:\s*(\S+)\s+(?:\((\S+)\)\s+)?(raid\d+|linear|multipath)?\s*(.*)$`)
+	// 976629568 blocks super 1.2 [2/1] [U_]
+	mdDisksRe = regexp.MustCompile(`\[(\d+)/(\d+)\]\s+\[([U_]+)\]`)
+	// [=>....]  recovery =  8.5% (83031424/976629568) finish=74.3min speed=200234K/sec
+	mdSyncRe = regexp.MustCompile(`(resync|recovery|reshape|check|repair)\s*=\s*([\d.]+)%\s*\((\d+)/(\d+)\).*speed=(\d+)K/sec`)
+	// resync=DELAYED or resync=PENDING
+	mdSyncPendingRe = regexp.MustCompile(`(resync|recovery|reshape|check|repair)\s*=\s*(DELAYED|PENDING)`)
+)
+
+// Collect parses /proc/mdstat and md attributes in sysfs
+func (s *MDStat) Collect() {
+	file, err := os.Open(root + "proc/mdstat")
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var o *PerArrayStat
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := mdArrayRe.FindStringSubmatch(line); m != nil {
+			var ok bool
+			o, ok = s.Arrays[m[1]]
+			if !ok {
+				o = NewPerArrayStat(s.m, m[1])
+				s.Arrays[m[1]] = o
+			}
+			seen[m[1]] = true
+			o.parseArrayLine(m)
+			continue
+		}
+		if o == nil {
+			continue
+		}
+		if m := mdDisksRe.FindStringSubmatch(line); m != nil {
+			o.Metrics.RaidDisks.Set(float64(misc.ParseUint(m[1])))
+			o.Metrics.ActiveDisks.Set(float64(misc.ParseUint(m[2])))
+			o.Metrics.Degraded.Set(float64(strings.Count(m[3], "_")))
+		}
+		if m := mdSyncRe.FindStringSubmatch(line); m != nil {
+			o.SyncAction = m[1]
+			o.Metrics.SyncCompleted.Set(misc.ParseFloat(m[2]))
+			o.syncPosition = misc.ParseUint(m[3])
+			o.Metrics.SyncSpeed.Set(float64(misc.ParseUint(m[5])))
+		} else if m := mdSyncPendingRe.FindStringSubmatch(line); m != nil {
+			o.SyncAction = m[1]
+			o.pending = true
+			o.Metrics.SyncSpeed.Set(0)
+		}
+	}
+	for name, o := range s.Arrays {
+		if !seen[name] {
+			o.Unregister()
+			delete(s.Arrays, name)
+			continue
+		}
+		o.collectSysfs()
+		o.trackProgress(now())
+	}
+}
+
+// ByName returns arrays sorted by name
+func (s *MDStat) ByName() []*PerArrayStat {
+	var v []*PerArrayStat
+	for _, o := range s.Arrays {
+		v = append(v, o)
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].Name < v[j].Name })
+	return v
+}
+
+// PerArrayStat represents statistics for a single md array
+type PerArrayStat struct {
+	Metrics       *PerArrayStatMetrics
+	Name          string
+	Level         string   // raid1, raid10...
+	State         string   // array_state: clean, active, degraded...
+	SyncAction    string   // idle, resync, recover, check, repair...
+	Devices       []string // member devices
+	FailedDevices []string // member devices marked faulty
+	syncPosition  uint64   // blocks synced so far as per /proc/mdstat
+	lastPosition  uint64
+	lastProgress  time.Time // last time the sync was seen advancing
+	pending       bool      // resync delayed until other arrays are done
+	m             *metrics.MetricContext
+}
+
+// PerArrayStatMetrics represents statistics automatically initialized
+// per md array
+type PerArrayStatMetrics struct {
+	RaidDisks     *metrics.Gauge
+	ActiveDisks   *metrics.Gauge
+	Degraded      *metrics.Gauge // number of missing/failed devices
+	SyncCompleted *metrics.Gauge // percentage of resync/recovery done
+	SyncSpeed     *metrics.Gauge // KB/s
+	MismatchCnt   *metrics.Gauge
+}
+
+// NewPerArrayStat registers with metriccontext for a md array
+func NewPerArrayStat(m *metrics.MetricContext, name string) *PerArrayStat {
+	s := new(PerArrayStat)
+	s.Name = name
+	s.m = m
+	s.Metrics = new(PerArrayStatMetrics)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s.Metrics, m, "mdstat."+name, true)
+	return s
+}
+
+// Unregister removes metrics from metric-context
+func (s *PerArrayStat) Unregister() {
+	misc.UnregisterMetrics(s.Metrics, s.m, "mdstat."+s.Name)
+}
+
+// IsDegraded returns true if the array is missing devices or has
+// failed devices
+func (s *PerArrayStat) IsDegraded() bool {
+	return s.Metrics.Degraded.Get() > 0 || len(s.FailedDevices) > 0
+}
+
+// IsSyncing returns true if the array is being resynced, recovered,
+// reshaped or checked
+func (s *PerArrayStat) IsSyncing() bool {
+	return s.SyncAction != "" && s.SyncAction != "idle"
+}
+
+// IsStalled returns true if a resync or recovery made no progress for
+// stallTimeout
+func (s *PerArrayStat) IsStalled() bool {
+	return s.IsSyncing() && !s.pending && now().Sub(s.lastProgress) >= stallTimeout
+}
+
+// Unexported functions
+
+// parseArrayLine parses "md1 : active raid1 sdb2[1](F) sda2[0]"
+func (s *PerArrayStat) parseArrayLine(m []string) {
+	s.State = m[2]
+	s.Level = m[4]
+	s.SyncAction = "idle"
+	s.pending = false
+	s.syncPosition = 0
+	s.Metrics.SyncCompleted.Set(100)
+	s.Metrics.SyncSpeed.Set(0)
+	s.Metrics.Degraded.Set(0)
+	s.Devices = s.Devices[:0]
+	s.FailedDevices = s.FailedDevices[:0]
+	for _, dev := range strings.Fields(m[5]) {
+		name := dev
+		if i := strings.Index(dev, "["); i > 0 {
+			name = dev[:i]
+		}
+		s.Devices = append(s.Devices, name)
+		if strings.HasSuffix(dev, "(F)") {
+			s.FailedDevices = append(s.FailedDevices, name)
+		}
+	}
+}
+
+// collectSysfs reads /sys/block/<md>/md attributes which are more
+// precise than /proc/mdstat where available. sync_completed only
+// advances at checkpoints minutes apart, so it is used for the
+// percentage done only if /proc/mdstat did not report a position.
+func (s *PerArrayStat) collectSysfs() {
+	dir := root + "sys/block/" + s.Name + "/md/"
+	if v := readString(dir + "array_state"); v != "" {
+		s.State = v
+	}
+	if v := readString(dir + "sync_action"); v != "" {
+		s.SyncAction = v
+	}
+	if v := readString(dir + "degraded"); v != "" {
+		s.Metrics.Degraded.Set(float64(misc.ParseUint(v)))
+	}
+	if v := readString(dir + "mismatch_cnt"); v != "" {
+		s.Metrics.MismatchCnt.Set(float64(misc.ParseUint(v)))
+	}
+	if v := readString(dir + "sync_speed"); v != "" && s.IsSyncing() {
+		s.Metrics.SyncSpeed.Set(float64(misc.ParseUint(v)))
+	}
+	// sync_completed is "none" or "<done> / <total>" in sectors
+	f := strings.Fields(readString(dir + "sync_completed"))
+	if len(f) == 3 && f[1] == "/" && s.syncPosition == 0 {
+		done := misc.ParseUint(f[0])
+		total := misc.ParseUint(f[2])
+		if total > 0 {
+			s.Metrics.SyncCompleted.Set(float64(done) / float64(total) * 100)
+		}
+	}
+}
+
+// trackProgress remembers when a resync was last seen advancing,
+// either by its position in /proc/mdstat or by a non-zero speed
+func (s *PerArrayStat) trackProgress(t time.Time) {
+	if !s.IsSyncing() || s.syncPosition != s.lastPosition ||
+		s.Metrics.SyncSpeed.Get() > 0 || s.lastProgress.IsZero() {
+		s.lastProgress = t
+	}
+	s.lastPosition = s.syncPosition
+}
+
+// readString returns trimmed contents of a sysfs attribute
+func readString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
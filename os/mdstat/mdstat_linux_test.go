@@ -0,0 +1,69 @@
+// Copyright (c) 2015 Square, Inc
+
+package mdstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+// collectAt collects statistics from fixture dir as of time t
+func collectAt(s *MDStat, dir string, t time.Time) {
+	root = dir
+	now = func() time.Time { return t }
+	s.Collect()
+}
+
+func TestMDStat(t *testing.T) {
+	defer func() { now = time.Now }()
+	m := metrics.NewMetricContext("system")
+	s := &MDStat{Arrays: make(map[string]*PerArrayStat), m: m}
+	start := time.Unix(1400000000, 0)
+	collectAt(s, "testdata/t0/", start)
+	collectAt(s, "testdata/t1/", start.Add(2*time.Second))
+	if len(s.Arrays) != 4 {
+		t.Fatalf("Expected 4 arrays, got %v", len(s.Arrays))
+	}
+	md0 := s.Arrays["md0"]
+	if md0.IsDegraded() || md0.IsSyncing() || md0.Level != "raid1" {
+		t.Errorf("Expected md0 to be a healthy raid1 array")
+	}
+	md1 := s.Arrays["md1"]
+	if !md1.IsDegraded() || md1.FailedDevices[0] != "sdb2" {
+		t.Errorf("Expected md1 to be degraded with sdb2 failed")
+	}
+	if md1.SyncAction != "recovery" {
+		t.Errorf("Expected md1 to be recovering, got %v", md1.SyncAction)
+	}
+	if actual := md1.Metrics.SyncCompleted.Get(); actual != 10.2 {
+		t.Errorf("md1 sync completed: %v expected: 10.2", actual)
+	}
+	md2 := s.Arrays["md2"]
+	if md2.Level != "raid10" || md2.IsStalled() {
+		t.Errorf("Expected md2 raid10 resync not to be stalled yet")
+	}
+	if actual := md2.Metrics.MismatchCnt.Get(); actual != 128 {
+		t.Errorf("md2 mismatch_cnt: %v expected: 128", actual)
+	}
+	md3 := s.Arrays["md3"]
+	if !md3.IsSyncing() || md3.IsStalled() {
+		t.Errorf("Expected delayed resync of md3 not to be stalled")
+	}
+	// sync_completed of md1 stays at its last checkpoint while
+	// /proc/mdstat shows recovery moving on
+	collectAt(s, "testdata/t2/", start.Add(90*time.Second))
+	if md1.IsStalled() {
+		t.Errorf("Expected md1 recovery between checkpoints not to be stalled")
+	}
+	if actual := md1.Metrics.SyncCompleted.Get(); actual != 11.9 {
+		t.Errorf("md1 sync completed: %v expected: 11.9", actual)
+	}
+	if !md2.IsStalled() {
+		t.Errorf("Expected md2 raid10 resync to be stalled")
+	}
+	if md3.IsStalled() {
+		t.Errorf("Expected delayed resync of md3 not to be stalled")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/container"
 	"github.com/square/inspect/os/misc"
 )
 
@@ -118,8 +119,11 @@ type PerCgroupStat struct {
 	Soft_Limit_In_Bytes *metrics.Gauge
 	// Approximate usage in bytes
 	UsageInBytes *metrics.Gauge
-	path         string
-	prefix       string
+	// Container is the container this cgroup belongs to (nil otherwise)
+	Container *container.Container
+	path      string
+	prefix    string
+	rel       string
 }
 
 // NewPerCgroupStat registers with metriccontext for a particular cgroup
@@ -128,6 +132,7 @@ func NewPerCgroupStat(m *metrics.MetricContext, path string, mp string) *PerCgro
 	c.m = m
 	c.path = path
 	rel, _ := filepath.Rel(mp, path)
+	c.rel = rel
 	// initialize all metrics and register them
 	c.prefix = "memstat.cgroup." + rel
 	misc.InitializeMetrics(c, m, c.prefix, true)
@@ -160,6 +165,9 @@ func (s *PerCgroupStat) SoftLimit() float64 {
 
 // Collect reads memory.stat and uses reflection to populate PerCgroupStat
 func (s *PerCgroupStat) Collect() {
+	s.Container = container.DefaultResolver.ResolveMetrics(s.Container, "/"+s.rel,
+		s, s.m, s.prefix)
+
 	file, err := os.Open(s.path + "/" + "memory.stat")
 	if err != nil {
 		fmt.Println(err)
@@ -195,6 +203,7 @@ func (s *PerCgroupStat) Collect() {
 }
 
 // Unexported functions
+
 func parseCgroupMemLine(g *metrics.Gauge, f []string) {
 	length := len(f)
 	val := math.NaN()
@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Square, Inc
+
+package misc
+
+import (
+	"math"
+	"time"
+)
+
+// HistoryMinSamples is the number of samples needed before growth of
+// a History is estimated
+const HistoryMinSamples = 5
+
+// History keeps samples of a value over a rolling window to estimate
+// how fast it grows
+type History struct {
+	Window time.Duration
+	times  []float64 // seconds
+	values []float64
+}
+
+// Add records a sample and drops samples which fell out of the window
+func (h *History) Add(t time.Time, v float64) {
+	ts := float64(t.UnixNano()) / float64(time.Second)
+	h.times = append(h.times, ts)
+	h.values = append(h.values, v)
+	cutoff := ts - h.Window.Seconds()
+	i := 0
+	for i < len(h.times)-1 && h.times[i] < cutoff {
+		i++
+	}
+	if i > 0 {
+		// reuse backing arrays
+		n := copy(h.times, h.times[i:])
+		h.times = h.times[:n]
+		copy(h.values, h.values[i:])
+		h.values = h.values[:n]
+	}
+}
+
+// Len returns number of samples in the window
+func (h *History) Len() int {
+	return len(h.times)
+}
+
+// Span returns time between the oldest and the newest sample
+func (h *History) Span() time.Duration {
+	if len(h.times) == 0 {
+		return 0
+	}
+	return time.Duration((h.times[len(h.times)-1] - h.times[0]) * float64(time.Second))
+}
+
+// Values returns samples in the window, oldest first
+func (h *History) Values() []float64 {
+	return h.values
+}
+
+// Growth returns slope of the least squares fit of samples per second
+// or NaN if there is not enough history
+func (h *History) Growth() float64 {
+	slope, _ := h.fit()
+	return slope
+}
+
+// Correlation returns correlation coefficient of samples with time,
+// close to 1 when samples follow a rising line and close to 0 when they
+// are noise, or NaN if there is not enough history
+func (h *History) Correlation() float64 {
+	_, r := h.fit()
+	return r
+}
+
+// fit returns slope and correlation coefficient of the least squares
+// fit of samples
+func (h *History) fit() (float64, float64) {
+	if len(h.times) < HistoryMinSamples {
+		return math.NaN(), math.NaN()
+	}
+	n := float64(len(h.times))
+	var sx, sy float64
+	for i := range h.times {
+		sx += h.times[i]
+		sy += h.values[i]
+	}
+	mx, my := sx/n, sy/n
+	var num, dxx, dyy float64
+	for i := range h.times {
+		dx := h.times[i] - mx
+		dy := h.values[i] - my
+		num += dx * dy
+		dxx += dx * dx
+		dyy += dy * dy
+	}
+	if dxx == 0 {
+		return math.NaN(), math.NaN()
+	}
+	if dyy == 0 {
+		return 0, 0
+	}
+	return num / dxx, num / math.Sqrt(dxx*dyy)
+}
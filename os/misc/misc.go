@@ -133,6 +133,25 @@ func extractValue(name string, keys []string, values []string) (string, error) {
 	return "", errors.New("Not found")
 }
 
+// RegisterMetrics registers all counters/gauges defined for the instance
+// that were initialized earlier without registration
+func RegisterMetrics(c Interface, m *metrics.MetricContext, prefix string) {
+	s := reflect.ValueOf(c).Elem()
+	typeOfT := s.Type()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.Kind().String() != "ptr" || f.IsNil() {
+			continue
+		}
+		if f.Type().Elem() == reflect.TypeOf(metrics.Gauge{}) ||
+			f.Type().Elem() == reflect.TypeOf(metrics.Counter{}) {
+			name := prefix + "." + typeOfT.Field(i).Name
+			m.Register(f.Interface(), name)
+		}
+	}
+	return
+}
+
 // UnregisterMetrics un-registers all counters/gauges defined for the instance
 func UnregisterMetrics(c Interface, m *metrics.MetricContext, prefix string) {
 	s := reflect.ValueOf(c).Elem()
@@ -151,6 +170,24 @@ func UnregisterMetrics(c Interface, m *metrics.MetricContext, prefix string) {
 	return
 }
 
+// SetMetadata attaches key/value pairs to all counters/gauges defined
+// for the instance
+func SetMetadata(c Interface, m *metrics.MetricContext, prefix string, md map[string]string) {
+	s := reflect.ValueOf(c).Elem()
+	typeOfT := s.Type()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.Kind().String() != "ptr" {
+			continue
+		}
+		if f.Type().Elem() == reflect.TypeOf(metrics.Gauge{}) ||
+			f.Type().Elem() == reflect.TypeOf(metrics.Counter{}) {
+			m.SetMetadata(prefix+"."+typeOfT.Field(i).Name, md)
+		}
+	}
+	return
+}
+
 // FindCgroupMount returns the file system mount point where the input
 // subsystem is mounted at.
 // TODO: move these to cgroup library
@@ -5,6 +5,7 @@ package misc
 import (
 	"math"
 	"testing"
+	"time"
 )
 
 var parseUintTests = []struct {
@@ -43,3 +44,25 @@ func TestParseFloat(t *testing.T) {
 		}
 	}
 }
+
+func TestHistory(t *testing.T) {
+	h := &History{Window: 5 * time.Minute}
+	start := time.Unix(1400000000, 0)
+	for i := 0; i < HistoryMinSamples; i++ {
+		if !math.IsNaN(h.Growth()) {
+			t.Errorf("History growth with %v samples => %v, want NaN", i, h.Growth())
+		}
+		h.Add(start.Add(time.Duration(i)*time.Minute), float64(60*i))
+	}
+	if actual := h.Growth(); math.Abs(actual-1) > 1e-9 {
+		t.Errorf("History growth => %v, want 1", actual)
+	}
+	if actual := h.Correlation(); math.Abs(actual-1) > 1e-9 {
+		t.Errorf("History correlation => %v, want 1", actual)
+	}
+	// samples older than the window are dropped
+	h.Add(start.Add(8*time.Minute), 480)
+	if h.Len() != 3 {
+		t.Errorf("History samples => %v, want 3", h.Len())
+	}
+}
@@ -22,6 +22,7 @@ type NetStat struct {
 	TCPStat         TCPStat
 	UDPStat         UDPStat
 	ExtendedMetrics ExtendedMetrics
+	SockStat        SockStat
 
 	m *metrics.MetricContext
 }
@@ -73,6 +74,7 @@ func New(m *metrics.MetricContext, Step time.Duration) *NetStat {
 	misc.InitializeMetrics(&s.TCPStat, m, "tcpstat", true)
 	misc.InitializeMetrics(&s.UDPStat, m, "udpstat", true)
 	misc.InitializeMetrics(&s.ExtendedMetrics, m, "tcpstat.ext", true)
+	misc.InitializeMetrics(&s.SockStat, m, "sockstat", true)
 	// collect once
 	s.Collect()
 	// collect metrics every Step
@@ -85,7 +87,8 @@ func New(m *metrics.MetricContext, Step time.Duration) *NetStat {
 	return s
 }
 
-// Collect populates NetStat by reading /proc/net/snmp and /proc/net/netstat
+// Collect populates NetStat by reading /proc/net/snmp, /proc/net/netstat
+// and /proc/net/sockstat
 func (s *NetStat) Collect() {
 	if snmp, err := ioutil.ReadFile(root + "proc/net/snmp"); err == nil {
 		populateMetrics(s.m, &s.TCPStat, snmp, "Tcp:")
@@ -94,6 +97,7 @@ func (s *NetStat) Collect() {
 	if netstat, err := ioutil.ReadFile(root + "proc/net/netstat"); err == nil {
 		populateMetrics(s.m, &s.ExtendedMetrics, netstat, "TcpExt:")
 	}
+	s.SockStat.Collect()
 }
 
 // Unexported functions
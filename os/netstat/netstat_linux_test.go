@@ -32,3 +32,74 @@ func TestUDPStat(t *testing.T) {
 		t.Error("UDPStat OutDatagrams expected 248067 actual", actual)
 	}
 }
+
+func TestSocketTable(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	s := NewSocketTable(m, time.Millisecond*50)
+	time.Sleep(time.Millisecond * 200)
+	if v := s.States.Listen.Get(); v != 5 {
+		t.Errorf("Listen sockets: %v expected: 5", v)
+	}
+	if v := s.States.CloseWait.Get(); v != 3 {
+		t.Errorf("CloseWait sockets: %v expected: 3", v)
+	}
+	if v := s.States.SynRecv.Get(); v != 1 {
+		t.Errorf("SynRecv sockets: %v expected: 1", v)
+	}
+	listeners := s.ListenersByQueueUsage()
+	if len(listeners) != 4 {
+		t.Fatalf("Expected 4 listeners, got %v", len(listeners))
+	}
+	l := listeners[0]
+	if l.Name != "0.0.0.0:80" || l.QueueUsage() != 100 || l.Comm != "nginx" {
+		t.Errorf("Unexpected top listener: %v %v%% %v", l.Name, l.QueueUsage(), l.Comm)
+	}
+	if l := s.Listeners["[::]:22"]; l == nil || l.Pid != "200" {
+		t.Errorf("Expected listener [::]:22 owned by pid 200")
+	}
+	owners := s.CloseWaitByProcess()
+	if len(owners) != 2 || owners[0].Pid != "100" || owners[0].Count != 2 {
+		t.Errorf("Unexpected CLOSE_WAIT owners: %v", owners)
+	}
+}
+
+func TestSocketTableReusePort(t *testing.T) {
+	// two sockets listen on 0.0.0.0:8080 with SO_REUSEPORT
+	m := metrics.NewMetricContext("system")
+	s := NewSocketTable(m, time.Hour)
+	l := s.Listeners["0.0.0.0:8080"]
+	if l == nil {
+		t.Fatalf("Expected a listener on 0.0.0.0:8080, got %v", s.Listeners)
+	}
+	if l.Sockets != 2 || l.AcceptQueue.Get() != 8 || l.Backlog.Get() != 256 {
+		t.Errorf("Unexpected listener: %v sockets, accept queue %v of %v",
+			l.Sockets, l.AcceptQueue.Get(), l.Backlog.Get())
+	}
+	if l.Comm != "envoy" {
+		t.Errorf("Expected listener owned by envoy, got %q", l.Comm)
+	}
+	// metrics are kept across collections
+	s.Collect()
+	if s.Listeners["0.0.0.0:8080"] != l {
+		t.Errorf("Listener on 0.0.0.0:8080 was replaced")
+	}
+}
+
+func TestSockStat(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	stat := New(m, time.Millisecond*50)
+	time.Sleep(time.Millisecond * 200)
+	s := &stat.SockStat
+	if v := s.TCPOrphan.Get(); v != 28000 {
+		t.Errorf("TCP orphans: %v expected: 28000", v)
+	}
+	if v := s.TCP6Inuse.Get(); v != 22 {
+		t.Errorf("TCP6 inuse: %v expected: 22", v)
+	}
+	if v := s.OrphanUsage(); v < 85.4 || v > 85.5 {
+		t.Errorf("Orphan usage: %v expected: 85.4", v)
+	}
+	if v := s.TCPMemPressureUsage(); v < 95.3 || v > 95.4 {
+		t.Errorf("TCP memory pressure usage: %v expected: 95.3", v)
+	}
+}
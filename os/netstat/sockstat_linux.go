@@ -0,0 +1,133 @@
+// Copyright (c) 2015 Square, Inc
+
+package netstat
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// SockStat represents sockets in use per protocol and memory used by
+// them as reported by /proc/net/sockstat and /proc/net/sockstat6 along
+// with limits from net.ipv4.tcp_mem and net.ipv4.tcp_max_orphans.
+// Memory is measured in pages.
+// Caution: reflection is used to read this struct to discover names
+type SockStat struct {
+	SocketsUsed    *metrics.Gauge
+	TCPInuse       *metrics.Gauge
+	TCPOrphan      *metrics.Gauge
+	TCPTimeWait    *metrics.Gauge
+	TCPAlloc       *metrics.Gauge
+	TCPMem         *metrics.Gauge
+	UDPInuse       *metrics.Gauge
+	UDPMem         *metrics.Gauge
+	RAWInuse       *metrics.Gauge
+	FRAGInuse      *metrics.Gauge
+	FRAGMemory     *metrics.Gauge
+	TCP6Inuse      *metrics.Gauge
+	UDP6Inuse      *metrics.Gauge
+	RAW6Inuse      *metrics.Gauge
+	FRAG6Inuse     *metrics.Gauge
+	TCPMemMin      *metrics.Gauge // net.ipv4.tcp_mem
+	TCPMemPressure *metrics.Gauge
+	TCPMemMax      *metrics.Gauge
+	TCPMaxOrphans  *metrics.Gauge // net.ipv4.tcp_max_orphans
+}
+
+// Collect populates SockStat by reading /proc/net/sockstat,
+// /proc/net/sockstat6 and tcp sysctls
+func (s *SockStat) Collect() {
+	for _, name := range []string{"proc/net/sockstat", "proc/net/sockstat6"} {
+		file, err := os.Open(root + name)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0
+			f := strings.Fields(scanner.Text())
+			if len(f) < 3 {
+				continue
+			}
+			proto := strings.TrimSuffix(f[0], ":")
+			for i := 1; i+1 < len(f); i += 2 {
+				s.set(proto, f[i], float64(misc.ParseUint(f[i+1])))
+			}
+		}
+		file.Close()
+	}
+	if content, err := ioutil.ReadFile(root + "proc/sys/net/ipv4/tcp_mem"); err == nil {
+		f := strings.Fields(string(content))
+		if len(f) > 2 {
+			s.TCPMemMin.Set(float64(misc.ParseUint(f[0])))
+			s.TCPMemPressure.Set(float64(misc.ParseUint(f[1])))
+			s.TCPMemMax.Set(float64(misc.ParseUint(f[2])))
+		}
+	}
+	if content, err := ioutil.ReadFile(root + "proc/sys/net/ipv4/tcp_max_orphans"); err == nil {
+		s.TCPMaxOrphans.Set(float64(misc.ParseUint(strings.TrimSpace(string(content)))))
+	}
+}
+
+// TCPMemPressureUsage returns memory used by TCP as percentage of
+// the threshold above which the kernel starts moderating buffers
+func (s *SockStat) TCPMemPressureUsage() float64 {
+	return (s.TCPMem.Get() / s.TCPMemPressure.Get()) * 100
+}
+
+// TCPMemUsage returns memory used by TCP as percentage of the
+// maximum the kernel allows
+func (s *SockStat) TCPMemUsage() float64 {
+	return (s.TCPMem.Get() / s.TCPMemMax.Get()) * 100
+}
+
+// OrphanUsage returns orphaned TCP sockets as percentage of
+// net.ipv4.tcp_max_orphans
+func (s *SockStat) OrphanUsage() float64 {
+	return (s.TCPOrphan.Get() / s.TCPMaxOrphans.Get()) * 100
+}
+
+// set maps a protocol and key from sockstat to a metric
+func (s *SockStat) set(proto, key string, v float64) {
+	var g *metrics.Gauge
+	switch proto + "." + key {
+	case "sockets.used":
+		g = s.SocketsUsed
+	case "TCP.inuse":
+		g = s.TCPInuse
+	case "TCP.orphan":
+		g = s.TCPOrphan
+	case "TCP.tw":
+		g = s.TCPTimeWait
+	case "TCP.alloc":
+		g = s.TCPAlloc
+	case "TCP.mem":
+		g = s.TCPMem
+	case "UDP.inuse":
+		g = s.UDPInuse
+	case "UDP.mem":
+		g = s.UDPMem
+	case "RAW.inuse":
+		g = s.RAWInuse
+	case "FRAG.inuse":
+		g = s.FRAGInuse
+	case "FRAG.memory":
+		g = s.FRAGMemory
+	case "TCP6.inuse":
+		g = s.TCP6Inuse
+	case "UDP6.inuse":
+		g = s.UDP6Inuse
+	case "RAW6.inuse":
+		g = s.RAW6Inuse
+	case "FRAG6.inuse":
+		g = s.FRAG6Inuse
+	}
+	if g != nil {
+		g.Set(v)
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2015 Square, Inc
+
+package netstat
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// SocketTable represents TCP sockets found in /proc/net/tcp and
+// /proc/net/tcp6 summarized by state, accept queues of listening
+// sockets and processes holding sockets in CLOSE_WAIT
+type SocketTable struct {
+	States SocketStates
+	// Listeners maps local addresses to accept queues summed up over
+	// sockets listening on them; several with SO_REUSEPORT
+	Listeners map[string]*PerListenerStat
+	// CloseWait maps pids to sockets in CLOSE_WAIT they hold open
+	CloseWait map[string]int
+	m         *metrics.MetricContext
+}
+
+// SocketStates represents count of TCP sockets by state.
+// Caution: reflection is used to read this struct to discover names
+type SocketStates struct {
+	Established *metrics.Gauge
+	SynSent     *metrics.Gauge
+	SynRecv     *metrics.Gauge
+	FinWait1    *metrics.Gauge
+	FinWait2    *metrics.Gauge
+	TimeWait    *metrics.Gauge
+	Close       *metrics.Gauge
+	CloseWait   *metrics.Gauge
+	LastAck     *metrics.Gauge
+	Listen      *metrics.Gauge
+	Closing     *metrics.Gauge
+}
+
+// TCP states as found in include/net/tcp_states.h
+const (
+	tcpEstablished = 0x01
+	tcpSynSent     = 0x02
+	tcpSynRecv     = 0x03
+	tcpFinWait1    = 0x04
+	tcpFinWait2    = 0x05
+	tcpTimeWait    = 0x06
+	tcpClose       = 0x07
+	tcpCloseWait   = 0x08
+	tcpLastAck     = 0x09
+	tcpListen      = 0x0A
+	tcpClosing     = 0x0B
+	tcpNewSynRecv  = 0x0C
+)
+
+// NewSocketTable registers with metricscontext and starts collecting
+// TCP socket table every Step
+func NewSocketTable(m *metrics.MetricContext, Step time.Duration) *SocketTable {
+	s := new(SocketTable)
+	s.m = m
+	s.Listeners = make(map[string]*PerListenerStat)
+	s.CloseWait = make(map[string]int)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(&s.States, m, "tcpstat.sockets", true)
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// socket represents a single line from /proc/net/tcp{,6}
+type socket struct {
+	local   string
+	state   uint64
+	txQueue uint64
+	rxQueue uint64
+	inode   string
+}
+
+// Collect reads /proc/net/tcp and /proc/net/tcp6, counts sockets by state,
+// tracks accept queues of listening sockets and finds owners of
+// listening and CLOSE_WAIT sockets
+func (s *SocketTable) Collect() {
+	var sockets []socket
+	for _, name := range []string{"proc/net/tcp", "proc/net/tcp6"} {
+		sockets = append(sockets, readSockets(root+name)...)
+	}
+	counts := make(map[uint64]float64)
+	listening := make(map[string][]socket)
+	wanted := make(map[string]bool)
+	for _, sock := range sockets {
+		counts[sock.state]++
+		switch sock.state {
+		case tcpListen:
+			listening[sock.local] = append(listening[sock.local], sock)
+		case tcpCloseWait:
+			wanted[sock.inode] = true
+		}
+	}
+	for name, socks := range listening {
+		o, ok := s.Listeners[name]
+		if !ok {
+			o = NewPerListenerStat(s.m, name)
+			s.Listeners[name] = o
+		}
+		// for listening sockets the kernel reports current length of
+		// accept queue as rx_queue and backlog as tx_queue
+		var acceptQueue, backlog uint64
+		var inodes []string
+		for _, sock := range socks {
+			acceptQueue += sock.rxQueue
+			backlog += sock.txQueue
+			inodes = append(inodes, sock.inode)
+		}
+		sort.Strings(inodes)
+		// find the owner again if sockets on the address were replaced
+		if strings.Join(inodes, ",") != strings.Join(o.inodes, ",") {
+			o.inodes = inodes
+			o.Pid = ""
+			o.Comm = ""
+		}
+		o.AcceptQueue.Set(float64(acceptQueue))
+		o.Backlog.Set(float64(backlog))
+		o.Sockets = len(socks)
+		if o.Pid == "" {
+			for _, inode := range inodes {
+				wanted[inode] = true
+			}
+		}
+	}
+	// remove listeners which went away
+	for name, o := range s.Listeners {
+		if _, ok := listening[name]; !ok {
+			o.Unregister()
+			delete(s.Listeners, name)
+		}
+	}
+	st := &s.States
+	st.Established.Set(counts[tcpEstablished])
+	st.SynSent.Set(counts[tcpSynSent])
+	st.SynRecv.Set(counts[tcpSynRecv] + counts[tcpNewSynRecv])
+	st.FinWait1.Set(counts[tcpFinWait1])
+	st.FinWait2.Set(counts[tcpFinWait2])
+	st.TimeWait.Set(counts[tcpTimeWait])
+	st.Close.Set(counts[tcpClose])
+	st.CloseWait.Set(counts[tcpCloseWait])
+	st.LastAck.Set(counts[tcpLastAck])
+	st.Listen.Set(counts[tcpListen])
+	st.Closing.Set(counts[tcpClosing])
+
+	// walking through file descriptors of all processes is
+	// expensive - only do it if there are sockets to blame
+	owners := socketOwners(wanted)
+	for _, o := range s.Listeners {
+		if o.Pid != "" {
+			continue
+		}
+		for _, inode := range o.inodes {
+			if pid, ok := owners[inode]; ok {
+				o.Pid = pid
+				o.Comm = readComm(pid)
+				break
+			}
+		}
+	}
+	closeWait := make(map[string]int)
+	for _, sock := range sockets {
+		if sock.state != tcpCloseWait {
+			continue
+		}
+		if pid, ok := owners[sock.inode]; ok {
+			closeWait[pid]++
+		}
+	}
+	s.CloseWait = closeWait
+}
+
+// byQueueUsage represents list of listeners sorted by QueueUsage
+type byQueueUsage []*PerListenerStat
+
+func (a byQueueUsage) Len() int           { return len(a) }
+func (a byQueueUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byQueueUsage) Less(i, j int) bool { return a[i].QueueUsage() > a[j].QueueUsage() }
+
+// ListenersByQueueUsage returns an slice of *PerListenerStat entries
+// sorted by accept queue usage
+func (s *SocketTable) ListenersByQueueUsage() []*PerListenerStat {
+	var v []*PerListenerStat
+	for _, o := range s.Listeners {
+		if !math.IsNaN(o.QueueUsage()) {
+			v = append(v, o)
+		}
+	}
+	sort.Sort(byQueueUsage(v))
+	return v
+}
+
+// SocketOwner represents a process and number of sockets it holds
+type SocketOwner struct {
+	Pid   string
+	Comm  string
+	Count int
+}
+
+// CloseWaitByProcess returns processes holding sockets in CLOSE_WAIT
+// sorted by number of such sockets
+func (s *SocketTable) CloseWaitByProcess() []*SocketOwner {
+	var v []*SocketOwner
+	for pid, count := range s.CloseWait {
+		v = append(v, &SocketOwner{Pid: pid, Comm: readComm(pid), Count: count})
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].Count > v[j].Count })
+	return v
+}
+
+// PerListenerStat represents accept queue statistics for sockets
+// listening on a local address
+type PerListenerStat struct {
+	AcceptQueue *metrics.Gauge
+	Backlog     *metrics.Gauge
+	Name        string // local address:port
+	Sockets     int    // listening on the address with SO_REUSEPORT
+	Pid         string // owning process of one of the sockets, if known
+	Comm        string
+	inodes      []string // sorted
+	m           *metrics.MetricContext
+}
+
+// NewPerListenerStat registers with metriccontext for a listening socket
+func NewPerListenerStat(m *metrics.MetricContext, name string) *PerListenerStat {
+	s := new(PerListenerStat)
+	s.m = m
+	s.Name = name
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s, m, "tcpstat.listen."+name, true)
+	return s
+}
+
+// Unregister removes metrics from metric-context
+func (s *PerListenerStat) Unregister() {
+	misc.UnregisterMetrics(s, s.m, "tcpstat.listen."+s.Name)
+}
+
+// QueueUsage returns length of accept queue as percentage of backlog
+func (s *PerListenerStat) QueueUsage() float64 {
+	return (s.AcceptQueue.Get() / s.Backlog.Get()) * 100
+}
+
+// Unexported functions
+
+// readSockets parses a /proc/net/tcp{,6} file
+func readSockets(path string) []socket {
+	var sockets []socket
+	file, err := os.Open(path)
+	defer file.Close()
+	if err != nil {
+		return sockets
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode ...
+		f := strings.Fields(scanner.Text())
+		if len(f) < 10 {
+			continue
+		}
+		state, _ := strconv.ParseUint(f[3], 16, 8)
+		queues := strings.Split(f[4], ":")
+		if len(queues) != 2 {
+			continue
+		}
+		tx, _ := strconv.ParseUint(queues[0], 16, 64)
+		rx, _ := strconv.ParseUint(queues[1], 16, 64)
+		sockets = append(sockets, socket{
+			local:   parseSocketAddr(f[1]),
+			state:   state,
+			txQueue: tx,
+			rxQueue: rx,
+			inode:   f[9],
+		})
+	}
+	return sockets
+}
+
+// parseSocketAddr converts address:port in hex as found in /proc/net/tcp{,6}
+// to a printable address. Addresses are in host byte order (little
+// endian) per 32 bit word.
+func parseSocketAddr(in string) string {
+	f := strings.Split(in, ":")
+	if len(f) != 2 {
+		return in
+	}
+	b, err := hex.DecodeString(f[0])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return in
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	port, _ := strconv.ParseUint(f[1], 16, 16)
+	return net.JoinHostPort(net.IP(b).String(), fmt.Sprintf("%d", port))
+}
+
+var socketRe = regexp.MustCompile("^socket:\\[(\\d+)\\]$")
+
+// socketOwners walks through file descriptors of all processes to find
+// pids holding sockets identified by input inodes
+func socketOwners(inodes map[string]bool) map[string]string {
+	owners := make(map[string]string)
+	if len(inodes) == 0 {
+		return owners
+	}
+	pids, err := ioutil.ReadDir(root + "proc")
+	if err != nil {
+		return owners
+	}
+	for _, p := range pids {
+		pid := p.Name()
+		if !p.IsDir() || misc.ParseUint(pid) == 0 {
+			continue
+		}
+		fds, err := ioutil.ReadDir(root + "proc/" + pid + "/fd")
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(root + "proc/" + pid + "/fd/" + fd.Name())
+			if err != nil {
+				continue
+			}
+			m := socketRe.FindStringSubmatch(link)
+			if m != nil && inodes[m[1]] {
+				owners[m[1]] = pid
+				if len(owners) == len(inodes) {
+					return owners
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// readComm returns command name of the input pid
+func readComm(pid string) string {
+	content, err := ioutil.ReadFile(root + "proc/" + pid + "/comm")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
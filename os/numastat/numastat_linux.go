@@ -0,0 +1,245 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package numastat implements metrics collection related to NUMA
+// nodes: per-node memory usage and allocation locality
+package numastat
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// NUMAStat represents statistics for all NUMA nodes found on this OS
+type NUMAStat struct {
+	Nodes map[string]*PerNodeStat
+	m     *metrics.MetricContext
+}
+
+// New registers with metriccontext and collects per-node statistics
+// every Step
+func New(m *metrics.MetricContext, Step time.Duration) *NUMAStat {
+	s := new(NUMAStat)
+	s.Nodes = make(map[string]*PerNodeStat)
+	s.m = m
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect walks through /sys/devices/system/node and reads meminfo,
+// numastat and cpulist of every node
+func (s *NUMAStat) Collect() {
+	dirs, err := filepath.Glob(root + "sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		o, ok := s.Nodes[name]
+		if !ok {
+			o = NewPerNodeStat(s.m, name)
+			s.Nodes[name] = o
+		}
+		seen[name] = true
+		o.collect(dir + "/")
+	}
+	for name, o := range s.Nodes {
+		if !seen[name] {
+			o.Unregister()
+			delete(s.Nodes, name)
+		}
+	}
+}
+
+// byFree represents list of nodes sorted by free memory
+type byFree []*PerNodeStat
+
+func (a byFree) Len() int           { return len(a) }
+func (a byFree) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byFree) Less(i, j int) bool { return a[i].FreePct() < a[j].FreePct() }
+
+// ByFree returns nodes sorted by percentage of free memory, lowest
+// first
+func (s *NUMAStat) ByFree() []*PerNodeStat {
+	var v []*PerNodeStat
+	for _, o := range s.Nodes {
+		if !math.IsNaN(o.FreePct()) {
+			v = append(v, o)
+		}
+	}
+	sort.Sort(byFree(v))
+	return v
+}
+
+// PerNodeStat represents statistics for a single NUMA node
+type PerNodeStat struct {
+	Metrics *PerNodeStatMetrics
+	Name    string // node0, node1...
+	CPUList string // say 0-7,16-23
+	CPUs    []int
+	m       *metrics.MetricContext
+}
+
+// PerNodeStatMetrics represents statistics automatically initialized
+// per NUMA node
+type PerNodeStatMetrics struct {
+	MemTotal      *metrics.Gauge // bytes
+	MemFree       *metrics.Gauge
+	FilePages     *metrics.Gauge
+	AnonPages     *metrics.Gauge
+	NumaHit       *metrics.Counter // allocations intended for and placed on node
+	NumaMiss      *metrics.Counter // allocations placed on node but intended elsewhere
+	NumaForeign   *metrics.Counter // allocations intended for node but placed elsewhere
+	InterleaveHit *metrics.Counter
+	LocalNode     *metrics.Counter // allocations on node by a process running on it
+	OtherNode     *metrics.Counter // allocations on node by a process running elsewhere
+}
+
+// NewPerNodeStat registers with metriccontext for a NUMA node
+func NewPerNodeStat(m *metrics.MetricContext, name string) *PerNodeStat {
+	s := new(PerNodeStat)
+	s.Name = name
+	s.m = m
+	s.Metrics = new(PerNodeStatMetrics)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s.Metrics, m, "numastat."+name, true)
+	return s
+}
+
+// Unregister removes metrics from metric-context
+func (s *PerNodeStat) Unregister() {
+	misc.UnregisterMetrics(s.Metrics, s.m, "numastat."+s.Name)
+}
+
+// Free returns free memory on the node in bytes including page cache
+// which can be reclaimed
+func (s *PerNodeStat) Free() float64 {
+	return s.Metrics.MemFree.Get() + s.Metrics.FilePages.Get()
+}
+
+// FreePct returns free memory including page cache as percentage of
+// memory on the node
+func (s *PerNodeStat) FreePct() float64 {
+	return s.Free() / s.Metrics.MemTotal.Get() * 100
+}
+
+// MissUsage returns percentage of allocations on the node over the
+// sampling interval which were intended for another node
+func (s *PerNodeStat) MissUsage() float64 {
+	hit := s.Metrics.NumaHit.ComputeRate()
+	miss := s.Metrics.NumaMiss.ComputeRate()
+	if hit+miss == 0 {
+		return 0
+	}
+	return miss / (hit + miss) * 100
+}
+
+// Unexported functions
+
+func (s *PerNodeStat) collect(dir string) {
+	s.collectMeminfo(dir + "meminfo")
+	s.collectNumastat(dir + "numastat")
+	content, err := ioutil.ReadFile(dir + "cpulist")
+	if err == nil {
+		s.CPUList = strings.TrimSpace(string(content))
+		s.CPUs = parseCPUList(s.CPUList)
+	}
+}
+
+// collectMeminfo parses lines like "Node 0 MemFree:  3393124 kB"
+func (s *PerNodeStat) collectMeminfo(path string) {
+	file, err := os.Open(path)
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	gauges := map[string]*metrics.Gauge{
+		"MemTotal":  s.Metrics.MemTotal,
+		"MemFree":   s.Metrics.MemFree,
+		"FilePages": s.Metrics.FilePages,
+		"AnonPages": s.Metrics.AnonPages,
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 4 {
+			continue
+		}
+		g, ok := gauges[strings.TrimSuffix(f[2], ":")]
+		if !ok {
+			continue
+		}
+		v := float64(misc.ParseUint(f[3]))
+		if len(f) > 4 && f[4] == "kB" {
+			v *= 1024
+		}
+		g.Set(v)
+	}
+}
+
+// collectNumastat parses lines like "numa_hit 11969850"
+func (s *PerNodeStat) collectNumastat(path string) {
+	file, err := os.Open(path)
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	counters := map[string]*metrics.Counter{
+		"numa_hit":       s.Metrics.NumaHit,
+		"numa_miss":      s.Metrics.NumaMiss,
+		"numa_foreign":   s.Metrics.NumaForeign,
+		"interleave_hit": s.Metrics.InterleaveHit,
+		"local_node":     s.Metrics.LocalNode,
+		"other_node":     s.Metrics.OtherNode,
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		if c, ok := counters[f[0]]; ok {
+			c.Set(misc.ParseUint(f[1]))
+		}
+	}
+}
+
+// parseCPUList expands list format used by sysfs, say 0-3,8,10-11
+func parseCPUList(list string) []int {
+	var cpus []int
+	for _, r := range strings.Split(list, ",") {
+		if r == "" {
+			continue
+		}
+		bounds := strings.SplitN(r, "-", 2)
+		lo := int(misc.ParseUint(bounds[0]))
+		hi := lo
+		if len(bounds) == 2 {
+			hi = int(misc.ParseUint(bounds[1]))
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Square, Inc
+
+package numastat
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestNUMAStat(t *testing.T) {
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := &NUMAStat{Nodes: make(map[string]*PerNodeStat), m: m}
+	// counters need two samples on distinct metrics ticks
+	time.Sleep(time.Millisecond * 200)
+	s.Collect()
+	time.Sleep(time.Millisecond * 200)
+	root = "testdata/t1/"
+	s.Collect()
+	if len(s.Nodes) != 2 {
+		t.Fatalf("numastat: %v nodes expected: 2", len(s.Nodes))
+	}
+	nodes := s.ByFree()
+	if nodes[0].Name != "node1" || nodes[1].Name != "node0" {
+		t.Errorf("numastat nodes by free: %v %v expected: node1 node0",
+			nodes[0].Name, nodes[1].Name)
+	}
+	node0, node1 := s.Nodes["node0"], s.Nodes["node1"]
+	if expected := float64(16777216 * 1024); node0.Metrics.MemTotal.Get() != expected {
+		t.Errorf("node0 MemTotal: %v expected: %v", node0.Metrics.MemTotal.Get(), expected)
+	}
+	if actual := node0.FreePct(); actual != 62.5 {
+		t.Errorf("node0 free: %v%% expected: 62.5%%", actual)
+	}
+	if actual := node1.FreePct(); actual != 1.5625 {
+		t.Errorf("node1 free: %v%% expected: 1.5625%%", actual)
+	}
+	if expected := float64((16777216 - 131072 - 131072) * 1024); node1.Metrics.AnonPages.Get() != expected {
+		t.Errorf("node1 AnonPages: %v expected: %v", node1.Metrics.AnonPages.Get(), expected)
+	}
+	// 10000 misses for 90000 hits
+	if actual := node1.MissUsage(); math.Abs(actual-10) > 1e-9 {
+		t.Errorf("node1 miss: %v%% expected: 10%%", actual)
+	}
+	if actual := node0.MissUsage(); actual != 0 {
+		t.Errorf("node0 miss: %v%% expected: 0%%", actual)
+	}
+	if expected := []int{4, 5, 6, 7, 12, 13, 14, 15}; !reflect.DeepEqual(node1.CPUs, expected) {
+		t.Errorf("node1 cpus: %v expected: %v", node1.CPUs, expected)
+	}
+}
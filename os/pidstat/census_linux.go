@@ -0,0 +1,290 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// censusHistory is the number of collections kept to judge whether
+// blocked tasks persist and zombies accumulate
+const censusHistory = 5
+
+// ProcessCensus represents counts of processes and threads in every
+// scheduler state across the whole system. Process states come from the
+// sample of every process ProcessStat takes; only
+// /proc/<pid>/task/<tid>/stat is read once per collection.
+type ProcessCensus struct {
+	Metrics       *ProcessCensusMetrics
+	Blocked       []*BlockedTask    // threads in uninterruptible sleep
+	ZombieParents map[string]uint64 // zombies by pid of their parent
+	blocked       []float64         // recent counts of blocked threads
+	zombies       []float64         // recent counts of zombie processes
+	pstat         *ProcessStat
+	m             *metrics.MetricContext
+}
+
+// ProcessCensusMetrics represents metrics maintained by ProcessCensus
+type ProcessCensusMetrics struct {
+	Processes        *metrics.Gauge
+	Running          *metrics.Gauge // processes in R state
+	Sleeping         *metrics.Gauge // S and I
+	DiskSleep        *metrics.Gauge // D
+	Zombie           *metrics.Gauge // Z
+	Stopped          *metrics.Gauge // T and t
+	Threads          *metrics.Gauge
+	ThreadsRunning   *metrics.Gauge
+	ThreadsSleeping  *metrics.Gauge
+	ThreadsDiskSleep *metrics.Gauge
+	ThreadsStopped   *metrics.Gauge
+	Forks            *metrics.Counter // "processes" in /proc/stat
+	ProcsRunning     *metrics.Gauge   // "procs_running" in /proc/stat
+	ProcsBlocked     *metrics.Gauge   // "procs_blocked" in /proc/stat
+}
+
+// BlockedTask represents a thread in uninterruptible sleep
+type BlockedTask struct {
+	Pid   string
+	Tid   string
+	Comm  string
+	Wchan string   // kernel function the thread is waiting in
+	Stack []string // kernel stack; readable only by root
+}
+
+// NewProcessCensus registers with metriccontext and counts processes
+// seen by pstat and their threads by state every Step
+func NewProcessCensus(m *metrics.MetricContext, pstat *ProcessStat, Step time.Duration) *ProcessCensus {
+	s := newProcessCensus(m, pstat)
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect counts processes seen by the last collection of ProcessStat
+// and their threads by state and reads fork and scheduler counts from
+// /proc/stat
+func (s *ProcessCensus) Collect() {
+	s.collectStat()
+	all := s.pstat.allProcesses()
+	// nothing to count until processes were collected
+	if len(all) == 0 {
+		return
+	}
+	procs := make(map[string]float64)
+	threads := make(map[string]float64)
+	var nprocs, nthreads float64
+	var blocked []*BlockedTask
+	zombieParents := make(map[string]uint64)
+	for _, p := range all {
+		pid, state := p.Pid(), p.State()
+		nprocs++
+		procs[state]++
+		if state == "Z" {
+			zombieParents[p.Ppid()]++
+		}
+		dir := root + "proc/" + pid + "/"
+		tids, err := readDirNames(dir + "task")
+		if err != nil || len(tids) == 0 {
+			// threads of zombies are gone; count the process itself
+			nthreads++
+			threads[state]++
+			continue
+		}
+		for _, tid := range tids {
+			tdir := dir + "task/" + tid + "/"
+			tst, ok := readStat(tdir + "stat")
+			if !ok {
+				continue
+			}
+			nthreads++
+			threads[tst.state]++
+			if tst.state == "D" {
+				blocked = append(blocked, &BlockedTask{
+					Pid:   pid,
+					Tid:   tid,
+					Comm:  tst.comm,
+					Wchan: readWchan(tdir + "wchan"),
+					Stack: readStack(tdir + "stack"),
+				})
+			}
+		}
+	}
+	o := s.Metrics
+	o.Processes.Set(nprocs)
+	o.Running.Set(procs["R"])
+	o.Sleeping.Set(procs["S"] + procs["I"])
+	o.DiskSleep.Set(procs["D"])
+	o.Zombie.Set(procs["Z"])
+	o.Stopped.Set(procs["T"] + procs["t"])
+	o.Threads.Set(nthreads)
+	o.ThreadsRunning.Set(threads["R"])
+	o.ThreadsSleeping.Set(threads["S"] + threads["I"])
+	o.ThreadsDiskSleep.Set(threads["D"])
+	o.ThreadsStopped.Set(threads["T"] + threads["t"])
+	sort.Slice(blocked, func(i, j int) bool {
+		return misc.ParseUint(blocked[i].Tid) < misc.ParseUint(blocked[j].Tid)
+	})
+	s.Blocked = blocked
+	s.ZombieParents = zombieParents
+	s.blocked = appendHistory(s.blocked, threads["D"])
+	s.zombies = appendHistory(s.zombies, procs["Z"])
+}
+
+// ForkRate returns processes and threads created per second
+func (s *ProcessCensus) ForkRate() float64 {
+	return s.Metrics.Forks.ComputeRate()
+}
+
+// SustainedBlocked returns the smallest number of threads in
+// uninterruptible sleep seen over the last few collections; 0 until
+// there is enough history
+func (s *ProcessCensus) SustainedBlocked() float64 {
+	if len(s.blocked) < censusHistory {
+		return 0
+	}
+	min := s.blocked[0]
+	for _, v := range s.blocked {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// ZombieGrowth returns increase in zombie processes over the last few
+// collections if their number never went down; 0 otherwise
+func (s *ProcessCensus) ZombieGrowth() float64 {
+	if len(s.zombies) < censusHistory {
+		return 0
+	}
+	for i := 1; i < len(s.zombies); i++ {
+		if s.zombies[i] < s.zombies[i-1] {
+			return 0
+		}
+	}
+	return s.zombies[len(s.zombies)-1] - s.zombies[0]
+}
+
+// TopZombieParent returns pid and command of the process with most
+// zombie children and their number
+func (s *ProcessCensus) TopZombieParent() (string, string, uint64) {
+	var top string
+	var max uint64
+	for ppid, n := range s.ZombieParents {
+		if n > max || (n == max && ppid < top) {
+			top, max = ppid, n
+		}
+	}
+	if top == "" {
+		return "", "", 0
+	}
+	for _, p := range s.pstat.allProcesses() {
+		if p.Pid() == top {
+			return top, p.Comm(), max
+		}
+	}
+	return top, "", max
+}
+
+// Unexported functions
+
+func newProcessCensus(m *metrics.MetricContext, pstat *ProcessStat) *ProcessCensus {
+	s := new(ProcessCensus)
+	s.m = m
+	s.pstat = pstat
+	s.Metrics = new(ProcessCensusMetrics)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s.Metrics, m, "pidstat.census", true)
+	return s
+}
+
+// collectStat reads processes, procs_running and procs_blocked lines
+// of /proc/stat
+func (s *ProcessCensus) collectStat() {
+	file, err := os.Open(root + "proc/stat")
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "processes":
+			s.Metrics.Forks.Set(misc.ParseUint(f[1]))
+		case "procs_running":
+			s.Metrics.ProcsRunning.Set(float64(misc.ParseUint(f[1])))
+		case "procs_blocked":
+			s.Metrics.ProcsBlocked.Set(float64(misc.ParseUint(f[1])))
+		}
+	}
+}
+
+func readWchan(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	wchan := strings.TrimSpace(string(content))
+	if wchan == "0" {
+		return ""
+	}
+	return wchan
+}
+
+// readStack returns function names from lines like
+// "[<0>] io_schedule+0x12/0x40"
+func readStack(path string) []string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var stack []string
+	for _, line := range strings.Split(string(content), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		fn := f[1]
+		if i := strings.Index(fn, "+"); i > 0 {
+			fn = fn[:i]
+		}
+		stack = append(stack, fn)
+	}
+	return stack
+}
+
+func appendHistory(h []float64, v float64) []float64 {
+	h = append(h, v)
+	if len(h) > censusHistory {
+		h = h[len(h)-censusHistory:]
+	}
+	return h
+}
+
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
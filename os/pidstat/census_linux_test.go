@@ -0,0 +1,77 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestProcessCensus(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t4")
+	s := newProcessCensus(m, pstat)
+	s.Collect()
+	o := s.Metrics
+	counts := []struct {
+		name     string
+		actual   float64
+		expected float64
+	}{
+		{"processes", o.Processes.Get(), 7},
+		{"running", o.Running.Get(), 1},
+		{"sleeping", o.Sleeping.Get(), 2},
+		{"disk sleep", o.DiskSleep.Get(), 1},
+		{"zombie", o.Zombie.Get(), 3},
+		{"threads", o.Threads.Get(), 9},
+		{"threads disk sleep", o.ThreadsDiskSleep.Get(), 3},
+		{"procs blocked", o.ProcsBlocked.Get(), 3},
+	}
+	for _, c := range counts {
+		if c.actual != c.expected {
+			t.Errorf("census %s: %v expected: %v", c.name, c.actual, c.expected)
+		}
+	}
+	if actual := o.Forks.Get(); actual != 50000 {
+		t.Errorf("census forks: %v expected: 50000", actual)
+	}
+	if len(s.Blocked) != 3 {
+		t.Fatalf("census blocked tasks: %v expected: 3", len(s.Blocked))
+	}
+	task := s.Blocked[0]
+	if task.Pid != "100" || task.Tid != "101" || task.Comm != "db io" ||
+		task.Wchan != "nfs_wait_bit_killable" {
+		t.Errorf("census blocked task: %+v", task)
+	}
+	if expected := []string{"io_schedule", "bit_wait_io", "__wait_on_bit"}; !reflect.DeepEqual(task.Stack, expected) {
+		t.Errorf("census blocked task stack: %v expected: %v", task.Stack, expected)
+	}
+	if s.Blocked[1].Wchan != "" || s.Blocked[1].Stack != nil {
+		t.Errorf("census blocked task without wchan/stack: %+v", s.Blocked[1])
+	}
+	if ppid, comm, n := s.TopZombieParent(); ppid != "1" || comm != "systemd" || n != 2 {
+		t.Errorf("census top zombie parent: %v/%v(%v) expected: 1/systemd(2)", ppid, comm, n)
+	}
+	// not enough history yet
+	if s.SustainedBlocked() != 0 || s.ZombieGrowth() != 0 {
+		t.Errorf("census sustained blocked/zombie growth without history")
+	}
+	for i := 0; i < censusHistory; i++ {
+		s.Collect()
+	}
+	if actual := s.SustainedBlocked(); actual != 3 {
+		t.Errorf("census sustained blocked: %v expected: 3", actual)
+	}
+	s.zombies = []float64{1, 1, 2, 2, 3}
+	if actual := s.ZombieGrowth(); actual != 2 {
+		t.Errorf("census zombie growth: %v expected: 2", actual)
+	}
+	s.zombies = []float64{1, 4, 2, 2, 3}
+	if actual := s.ZombieGrowth(); actual != 0 {
+		t.Errorf("census zombie growth after reaping: %v expected: 0", actual)
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// DefaultChurnWindow is the amount of history process starts, exits
+// and CPU of reaped children are summed up over
+const DefaultChurnWindow = time.Minute
+
+// ProcessChurn represents processes which start and exit between
+// samples and the CPU they used. CPU of exited processes is accounted
+// to their parent from growth of cutime/cstime in /proc/<pid>/stat.
+// Starts and exits by command are taken from the netlink process
+// connector when permitted (CAP_NET_ADMIN) and from differences
+// between samples of every process ProcessStat takes otherwise, which
+// miss processes living shorter than Step.
+type ProcessChurn struct {
+	Metrics   *ProcessChurnMetrics
+	window    time.Duration
+	procs     map[ProcessKey]churnProc // processes seen by the last sample
+	cycles    []*churnCycle
+	pending   *churnCycle  // events from the connector since the last sample
+	forked    map[int]bool // processes forked which did not exec yet
+	connector bool
+	last      time.Time // when the last sample was taken
+	pstat     *ProcessStat
+	mu        sync.Mutex
+	m         *metrics.MetricContext
+}
+
+// ProcessChurnMetrics represents metrics maintained by ProcessChurn
+type ProcessChurnMetrics struct {
+	Starts    *metrics.Counter
+	Exits     *metrics.Counter
+	ReapedCPU *metrics.Counter // clock ticks of children waited for
+}
+
+// ChurnEntry represents churn attributed to a command or a parent
+// process over the window
+type ChurnEntry struct {
+	Name   string  // command, or command(pid) of a parent
+	Starts float64 // per second
+	Exits  float64 // per second
+	CPU    float64 // logical CPUs used by exited processes
+}
+
+// NewProcessChurn registers with metriccontext, subscribes to process
+// events if permitted and compares samples of processes taken by pstat
+// every Step
+func NewProcessChurn(m *metrics.MetricContext, pstat *ProcessStat, Step time.Duration) *ProcessChurn {
+	s := newProcessChurn(m, pstat)
+	if c, err := openProcConnector(); err == nil {
+		s.connector = true
+		go func() {
+			defer c.close()
+			c.receive(s.handleEvent)
+			// fall back to scan diffing if the socket fails
+			s.mu.Lock()
+			s.connector = false
+			s.mu.Unlock()
+		}()
+	}
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// SetWindow sets amount of history churn is summed up over
+func (s *ProcessChurn) SetWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+// UsesConnector returns true if starts and exits are counted from
+// the netlink process connector
+func (s *ProcessChurn) UsesConnector() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connector
+}
+
+// Collect compares the last sample of ProcessStat with the previous one
+// accounting growth of CPU used by reaped children to their parents and,
+// unless the process connector is used, starts and exits to commands
+func (s *ProcessChurn) Collect() {
+	all, t := s.pstat.snapshot()
+	s.mu.Lock()
+	scan := !s.connector
+	first := s.procs == nil
+	last := s.last
+	s.mu.Unlock()
+	// nothing new was sampled since the last collection
+	if t.IsZero() || !t.After(last) {
+		return
+	}
+	cycle := newChurnCycle()
+	procs := make(map[ProcessKey]churnProc, len(s.procs))
+	for key, o := range all {
+		p := newChurnProc(o)
+		procs[key] = p
+		prev, ok := s.procs[key]
+		if !ok {
+			if scan && !first {
+				cycle.starts[p.comm]++
+			}
+			continue
+		}
+		if p.children > prev.children {
+			reaped := float64(p.children - prev.children)
+			cycle.reaped[p.comm+"("+key.Pid+")"] += reaped
+			cycle.reapedBy[key.Pid] += reaped
+			s.Metrics.ReapedCPU.Add(p.children - prev.children)
+		}
+	}
+	if scan {
+		// CPU reaped by a parent is split between its children seen
+		// exiting; children which came and went unseen only show up
+		// under their parent
+		exited := make(map[string][]string)
+		for key, prev := range s.procs {
+			if _, ok := procs[key]; !ok {
+				cycle.exits[prev.comm]++
+				exited[prev.ppid] = append(exited[prev.ppid], prev.comm)
+			}
+		}
+		for ppid, comms := range exited {
+			for _, comm := range comms {
+				cycle.cpu[comm] += cycle.reapedBy[ppid] / float64(len(comms))
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !scan {
+		cycle.merge(s.pending)
+		s.pending = newChurnCycle()
+		// forget processes whose exit was missed
+		live := make(map[string]bool, len(procs))
+		for key := range procs {
+			live[key.Pid] = true
+		}
+		for pid := range s.forked {
+			if !live[strconv.Itoa(pid)] {
+				delete(s.forked, pid)
+			}
+		}
+	}
+	s.procs = procs
+	if !first {
+		cycle.t = t
+		cycle.elapsed = t.Sub(s.last).Seconds()
+		s.cycles = append(s.cycles, cycle)
+	}
+	s.last = t
+	for _, v := range cycle.starts {
+		s.Metrics.Starts.Add(uint64(v))
+	}
+	for _, v := range cycle.exits {
+		s.Metrics.Exits.Add(uint64(v))
+	}
+	cutoff := t.Add(-s.window)
+	i := 0
+	for i < len(s.cycles)-1 && s.cycles[i].t.Before(cutoff) {
+		i++
+	}
+	s.cycles = s.cycles[i:]
+}
+
+// StartRate returns processes started per second over the window
+func (s *ProcessChurn) StartRate() float64 {
+	var starts float64
+	for _, o := range s.ByCommand() {
+		starts += o.Starts
+	}
+	return starts
+}
+
+// ExitRate returns processes exited per second over the window
+func (s *ProcessChurn) ExitRate() float64 {
+	var exits float64
+	for _, o := range s.ByCommand() {
+		exits += o.Exits
+	}
+	return exits
+}
+
+// ByCommand returns churn by command name sorted by CPU used by exited
+// processes and then by starts
+func (s *ProcessChurn) ByCommand() []*ChurnEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]*ChurnEntry)
+	get := func(name string) *ChurnEntry {
+		o, ok := entries[name]
+		if !ok {
+			o = &ChurnEntry{Name: name}
+			entries[name] = o
+		}
+		return o
+	}
+	span := s.span()
+	for _, c := range s.cycles {
+		for name, v := range c.starts {
+			get(name).Starts += v / span
+		}
+		for name, v := range c.exits {
+			get(name).Exits += v / span
+		}
+		for name, v := range c.cpu {
+			get(name).CPU += v / float64(linuxTicksInSec) / span
+		}
+	}
+	return sortChurn(entries)
+}
+
+// ByParent returns CPU used by children which exited and were waited
+// for by their parent, busiest parent first. CPU of grandchildren is
+// included.
+func (s *ProcessChurn) ByParent() []*ChurnEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]*ChurnEntry)
+	span := s.span()
+	for _, c := range s.cycles {
+		for name, v := range c.reaped {
+			o, ok := entries[name]
+			if !ok {
+				o = &ChurnEntry{Name: name}
+				entries[name] = o
+			}
+			o.CPU += v / float64(linuxTicksInSec) / span
+		}
+	}
+	return sortChurn(entries)
+}
+
+// Unexported functions
+
+func newProcessChurn(m *metrics.MetricContext, pstat *ProcessStat) *ProcessChurn {
+	s := new(ProcessChurn)
+	s.m = m
+	s.pstat = pstat
+	s.window = DefaultChurnWindow
+	s.pending = newChurnCycle()
+	s.forked = make(map[int]bool)
+	s.Metrics = new(ProcessChurnMetrics)
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s.Metrics, m, "pidstat.churn", true)
+	return s
+}
+
+// churnProc represents what is remembered about a process between samples
+type churnProc struct {
+	comm     string
+	ppid     string
+	children uint64 // cutime + cstime
+}
+
+func newChurnProc(o *PerProcessStat) churnProc {
+	m := o.Metrics
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return churnProc{m.comm, m.Ppid, m.children}
+}
+
+// churnCycle represents churn between two samples of processes
+type churnCycle struct {
+	t        time.Time
+	elapsed  float64            // seconds since the previous sample
+	starts   map[string]float64 // by command
+	exits    map[string]float64 // by command
+	cpu      map[string]float64 // clock ticks of exited processes by command
+	reaped   map[string]float64 // clock ticks of reaped children by parent
+	reapedBy map[string]float64 // same by pid of parent
+}
+
+func newChurnCycle() *churnCycle {
+	return &churnCycle{
+		starts:   make(map[string]float64),
+		exits:    make(map[string]float64),
+		cpu:      make(map[string]float64),
+		reaped:   make(map[string]float64),
+		reapedBy: make(map[string]float64),
+	}
+}
+
+func (c *churnCycle) merge(o *churnCycle) {
+	for k, v := range o.starts {
+		c.starts[k] += v
+	}
+	for k, v := range o.exits {
+		c.exits[k] += v
+	}
+	for k, v := range o.cpu {
+		c.cpu[k] += v
+	}
+}
+
+// span returns seconds covered by cycles in the window
+func (s *ProcessChurn) span() float64 {
+	var span float64
+	for _, c := range s.cycles {
+		span += c.elapsed
+	}
+	if span == 0 {
+		return 1
+	}
+	return span
+}
+
+// handleEvent counts processes started and exited, along with CPU they
+// used, from the process connector. Starts are counted by the command
+// exec'ed or, for processes which never exec, by their command at exit.
+// A process exits before its parent waits for it, so its stat is still
+// readable.
+func (s *ProcessChurn) handleEvent(e procEvent) {
+	if !e.isProcess() {
+		return
+	}
+	if e.what == procEventFork {
+		s.mu.Lock()
+		s.forked[e.pid] = true
+		s.mu.Unlock()
+		return
+	}
+	st, ok := readStat(root + "proc/" + strconv.Itoa(e.pid) + "/stat")
+	if !ok {
+		st.comm = "?"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e.what {
+	case procEventExec:
+		delete(s.forked, e.pid)
+		s.pending.starts[st.comm]++
+	case procEventExit:
+		if s.forked[e.pid] {
+			delete(s.forked, e.pid)
+			s.pending.starts[st.comm]++
+		}
+		s.pending.exits[st.comm]++
+		s.pending.cpu[st.comm] += float64(st.utime + st.stime)
+	}
+}
+
+func sortChurn(entries map[string]*ChurnEntry) []*ChurnEntry {
+	var v []*ChurnEntry
+	for _, o := range entries {
+		v = append(v, o)
+	}
+	sort.Slice(v, func(i, j int) bool {
+		if v[i].CPU != v[j].CPU {
+			return v[i].CPU > v[j].CPU
+		}
+		if v[i].Starts != v[j].Starts {
+			return v[i].Starts > v[j].Starts
+		}
+		return v[i].Name < v[j].Name
+	})
+	return v
+}
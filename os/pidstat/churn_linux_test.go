@@ -0,0 +1,62 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestProcessChurn(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	s := newProcessChurn(m, pstat)
+	collectFixtures(pstat, "t0")
+	s.Collect()
+	if len(s.ByCommand()) != 0 {
+		t.Errorf("churn after first walk: %v expected: none", s.ByCommand())
+	}
+	// pid 9813 exits and is reused by a process started later while
+	// init reaps 200 ticks worth of children
+	collectFixtures(pstat, "t5")
+	s.Collect()
+	// nothing changes until processes are sampled again
+	s.Collect()
+	commands := s.ByCommand()
+	if len(commands) != 1 || commands[0].Name != "perl 13" {
+		t.Fatalf("churn by command: %v expected: %v", commands, "perl 13")
+	}
+	if math.Abs(commands[0].Starts-1) > 0.1 || math.Abs(commands[0].Exits-1) > 0.1 {
+		t.Errorf("starts/exits of perl 13: %v/%v expected: %v/%v",
+			commands[0].Starts, commands[0].Exits, 1, 1)
+	}
+	parents := s.ByParent()
+	if len(parents) != 1 || parents[0].Name != "init(1)" {
+		t.Fatalf("churn by parent: %v expected: %v", parents, "init(1)")
+	}
+	expected := 200 / float64(linuxTicksInSec)
+	if math.Abs(parents[0].CPU-expected) > expected/10 {
+		t.Errorf("cpu reaped by init: %v expected: %v", parents[0].CPU, expected)
+	}
+	if s.Metrics.ReapedCPU.Get() != 200 || s.Metrics.Starts.Get() != 1 {
+		t.Errorf("reaped cpu/starts: %v/%v expected: %v/%v",
+			s.Metrics.ReapedCPU.Get(), s.Metrics.Starts.Get(), 200, 1)
+	}
+}
+
+func TestParseProcEvent(t *testing.T) {
+	// cn_msg for PROC_EVENT_EXIT of pid 4242
+	b := make([]byte, cnMsgLen+16+16)
+	nativeEndian.PutUint32(b[0:], cnIdxProc)
+	nativeEndian.PutUint32(b[4:], cnValProc)
+	nativeEndian.PutUint32(b[cnMsgLen:], procEventExit)
+	nativeEndian.PutUint32(b[cnMsgLen+16:], 4242)
+	nativeEndian.PutUint32(b[cnMsgLen+20:], 4242)
+	e, ok := parseProcEvent(b)
+	if !ok || e.what != procEventExit || e.pid != 4242 || !e.isProcess() {
+		t.Errorf("exit event: %+v expected pid: %v", e, 4242)
+	}
+}
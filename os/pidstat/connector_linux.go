@@ -0,0 +1,151 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// netlink process connector constants from linux/connector.h and
+// linux/cn_proc.h
+const (
+	netlinkConnector  = 11
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventExit = 0x80000000
+
+	cnMsgLen = 20 // struct cn_msg without data
+)
+
+// procEvent represents a fork, exec or exit of a thread
+type procEvent struct {
+	what uint32
+	pid  int // thread id
+	tgid int // process id
+	ppid int // parent process id for forks
+}
+
+// isProcess returns true if the event is about a process rather than
+// one of its threads
+func (e procEvent) isProcess() bool {
+	return e.pid == e.tgid
+}
+
+// procConnector receives events about processes from the kernel
+// through the netlink process connector which requires CAP_NET_ADMIN
+type procConnector struct {
+	fd int
+}
+
+// nativeEndian is the byte order used by netlink messages
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// openProcConnector subscribes to process events
+func openProcConnector() (*procConnector, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, netlinkConnector)
+	if err != nil {
+		return nil, err
+	}
+	c := &procConnector{fd}
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: cnIdxProc,
+		Pid:    uint32(os.Getpid()),
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		c.close()
+		return nil, err
+	}
+	// nlmsghdr, cn_msg and PROC_CN_MCAST_LISTEN
+	msg := make([]byte, syscall.NLMSG_HDRLEN+cnMsgLen+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[12:], uint32(os.Getpid()))
+	cn := msg[syscall.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(cn[0:], cnIdxProc)
+	nativeEndian.PutUint32(cn[4:], cnValProc)
+	nativeEndian.PutUint16(cn[16:], 4)
+	nativeEndian.PutUint32(cn[cnMsgLen:], procCnMcastListen)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// receive calls handle for every fork, exec and exit until the socket
+// fails
+func (c *procConnector) receive(handle func(procEvent)) error {
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.ENOBUFS {
+				// events were dropped; keep going
+				continue
+			}
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if e, ok := parseProcEvent(m.Data); ok {
+				handle(e)
+			}
+		}
+	}
+}
+
+func (c *procConnector) close() {
+	syscall.Close(c.fd)
+}
+
+// parseProcEvent parses cn_msg carrying struct proc_event
+func parseProcEvent(b []byte) (procEvent, bool) {
+	var e procEvent
+	if len(b) < cnMsgLen+16 {
+		return e, false
+	}
+	if nativeEndian.Uint32(b[0:]) != cnIdxProc || nativeEndian.Uint32(b[4:]) != cnValProc {
+		return e, false
+	}
+	// what, cpu, timestamp_ns and event data
+	ev := b[cnMsgLen:]
+	e.what = nativeEndian.Uint32(ev[0:])
+	data := ev[16:]
+	switch e.what {
+	case procEventFork:
+		// parent_pid, parent_tgid, child_pid, child_tgid
+		if len(data) < 16 {
+			return e, false
+		}
+		e.ppid = int(nativeEndian.Uint32(data[4:]))
+		e.pid = int(nativeEndian.Uint32(data[8:]))
+		e.tgid = int(nativeEndian.Uint32(data[12:]))
+	case procEventExec, procEventExit:
+		// process_pid, process_tgid
+		if len(data) < 8 {
+			return e, false
+		}
+		e.pid = int(nativeEndian.Uint32(data[0:]))
+		e.tgid = int(nativeEndian.Uint32(data[4:]))
+	default:
+		return e, false
+	}
+	return e, true
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"math"
+	"sort"
+
+	"github.com/square/inspect/os/container"
+)
+
+// GroupFunc represents a function that returns the name of the group
+// a process belongs to. Processes with the same name are aggregated.
+type GroupFunc func(pidstat *PerProcessStat) (group string)
+
+// GroupByComm groups processes by command name
+func GroupByComm(pidstat *PerProcessStat) string {
+	return pidstat.Comm()
+}
+
+// GroupByUser groups processes by username looked up by effective uid
+func GroupByUser(pidstat *PerProcessStat) string {
+	return pidstat.User()
+}
+
+// GroupByParent groups processes by pid of their parent
+func GroupByParent(pidstat *PerProcessStat) string {
+	return pidstat.Ppid()
+}
+
+// GroupBySession groups processes by session id
+func GroupBySession(pidstat *PerProcessStat) string {
+	return pidstat.Session()
+}
+
+// GroupByCgroup returns a GroupFunc which groups processes by the
+// cgroup they belong to for the input cgroup subsystem
+func GroupByCgroup(subsys string) GroupFunc {
+	return func(pidstat *PerProcessStat) string {
+		return pidstat.Cgroup(subsys)
+	}
+}
+
+// GroupByContainer groups processes by the container (or kubernetes pod)
+// their cpu cgroup belongs to. Processes outside containers are grouped
+// under "-".
+func GroupByContainer(pidstat *PerProcessStat) string {
+	c := container.DefaultResolver.Resolve(pidstat.Cgroup("cpu"))
+	if c == nil {
+		return "-"
+	}
+	return c.String()
+}
+
+// ProcessGroup represents usage summed up for a group of processes
+type ProcessGroup struct {
+	Name      string
+	Processes []*PerProcessStat
+	cpu       float64
+	mem       float64
+	io        float64
+}
+
+// CPUUsage returns amount of work done by processes in this group
+// Unit: # of logical CPUs
+func (g *ProcessGroup) CPUUsage() float64 {
+	return g.cpu
+}
+
+// MemUsage returns amount of memory resident for processes in this
+// group in bytes. Shared pages are counted once per process.
+func (g *ProcessGroup) MemUsage() float64 {
+	return g.mem
+}
+
+// IOUsage returns bytes read/written by processes in this group (bytes/sec)
+func (g *ProcessGroup) IOUsage() float64 {
+	return g.io
+}
+
+// Count returns number of processes in this group
+func (g *ProcessGroup) Count() int {
+	return len(g.Processes)
+}
+
+// Groups aggregates every process seen by the last collection by the
+// name returned by input GroupFunc, including processes rejected by the
+// pid filter since many small processes add up. Usage which is not
+// known (NaN) is skipped. Aggregating every process is not cheap; sort
+// the result with GroupsByCPUUsage and friends instead of calling
+// Groups for every order.
+func (s *ProcessStat) Groups(group GroupFunc) []*ProcessGroup {
+	h := make(map[string]*ProcessGroup)
+	var v []*ProcessGroup
+	for _, o := range s.allProcesses() {
+		name := group(o)
+		g, ok := h[name]
+		if !ok {
+			g = &ProcessGroup{Name: name}
+			h[name] = g
+			v = append(v, g)
+		}
+		g.Processes = append(g.Processes, o)
+		if cpu := o.CPUUsage(); !math.IsNaN(cpu) {
+			g.cpu += cpu
+		}
+		if mem := o.MemUsage(); !math.IsNaN(mem) {
+			g.mem += mem
+		}
+		if io := o.IOUsage(); !math.IsNaN(io) {
+			g.io += io
+		}
+	}
+	return v
+}
+
+type groupsByCPUUsage []*ProcessGroup
+
+func (a groupsByCPUUsage) Len() int           { return len(a) }
+func (a groupsByCPUUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a groupsByCPUUsage) Less(i, j int) bool { return a[i].CPUUsage() > a[j].CPUUsage() }
+
+// GroupsByCPUUsage returns a copy of groups returned by Groups sorted
+// by CPU usage
+func GroupsByCPUUsage(groups []*ProcessGroup) []*ProcessGroup {
+	v := append([]*ProcessGroup(nil), groups...)
+	sort.Sort(groupsByCPUUsage(v))
+	return v
+}
+
+type groupsByMemUsage []*ProcessGroup
+
+func (a groupsByMemUsage) Len() int           { return len(a) }
+func (a groupsByMemUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a groupsByMemUsage) Less(i, j int) bool { return a[i].MemUsage() > a[j].MemUsage() }
+
+// GroupsByMemUsage returns a copy of groups returned by Groups sorted
+// by Memory usage
+func GroupsByMemUsage(groups []*ProcessGroup) []*ProcessGroup {
+	v := append([]*ProcessGroup(nil), groups...)
+	sort.Sort(groupsByMemUsage(v))
+	return v
+}
+
+type groupsByIOUsage []*ProcessGroup
+
+func (a groupsByIOUsage) Len() int           { return len(a) }
+func (a groupsByIOUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a groupsByIOUsage) Less(i, j int) bool { return a[i].IOUsage() > a[j].IOUsage() }
+
+// GroupsByIOUsage returns a copy of groups returned by Groups sorted
+// by IO usage
+func GroupsByIOUsage(groups []*ProcessGroup) []*ProcessGroup {
+	v := append([]*ProcessGroup(nil), groups...)
+	sort.Sort(groupsByIOUsage(v))
+	return v
+}
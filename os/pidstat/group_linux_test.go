@@ -0,0 +1,61 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestGroupsByCPUUsage(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0", "t1", "t2")
+	top := GroupsByCPUUsage(pstat.Groups(GroupByCgroup("cpu")))[0]
+	if top.Name != "/batch" || top.Count() != 2 {
+		t.Errorf("top cgroup by cpu: %v(%v) expected: %v(%v)",
+			top.Name, top.Count(), "/batch", 2)
+	}
+	var expected = 1.0
+	actual := top.CPUUsage()
+	if math.Abs(actual-expected) > 0.001 {
+		t.Errorf("CPU usage for top cgroup: %v expected: %v", actual, expected)
+	}
+	top = GroupsByMemUsage(pstat.Groups(GroupByComm))[0]
+	if top.Name != "perl 13" || top.Count() != 2 {
+		t.Errorf("top comm by memory: %v(%v) expected: %v(%v)",
+			top.Name, top.Count(), "perl 13", 2)
+	}
+	groups := pstat.Groups(GroupBySession)
+	if len(groups) != 2 {
+		t.Errorf("number of sessions: %v expected: %v", len(groups), 2)
+	}
+}
+
+func TestGroupsOfFilteredProcesses(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	// workers below the threshold of the filter still add up
+	pstat.SetPidFilter(PidFilterFunc(func(p *PerProcessStat) bool {
+		return p.Comm() != "perl 13"
+	}))
+	collectFixtures(pstat, "t0", "t1", "t2")
+	if len(pstat.Processes) != 1 {
+		t.Errorf("tracked processes: %v expected: %v", len(pstat.Processes), 1)
+	}
+	top := GroupsByCPUUsage(pstat.Groups(GroupByComm))[0]
+	if top.Name != "perl 13" || top.Count() != 2 {
+		t.Fatalf("top comm by cpu: %v(%v) expected: %v(%v)",
+			top.Name, top.Count(), "perl 13", 2)
+	}
+	var expected = 1.0
+	actual := top.CPUUsage()
+	if math.Abs(actual-expected) > 0.001 {
+		t.Errorf("CPU usage for filtered group: %v expected: %v", actual, expected)
+	}
+}
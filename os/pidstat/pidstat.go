@@ -38,7 +38,7 @@ func (a byCPUUsage) Less(i, j int) bool { return a[i].CPUUsage() > a[j].CPUUsage
 // by CPU usage
 func (c *ProcessStat) ByCPUUsage() []*PerProcessStat {
 	var v []*PerProcessStat
-	for _, o := range c.Processes {
+	for _, o := range c.processes() {
 		if !math.IsNaN(o.CPUUsage()) {
 			v = append(v, o)
 		}
@@ -57,7 +57,7 @@ func (a byMemUsage) Less(i, j int) bool { return a[i].MemUsage() > a[j].MemUsage
 // by Memory usage
 func (c *ProcessStat) ByMemUsage() []*PerProcessStat {
 	var v []*PerProcessStat
-	for _, o := range c.Processes {
+	for _, o := range c.processes() {
 		if !math.IsNaN(o.MemUsage()) {
 			v = append(v, o)
 		}
@@ -91,6 +91,11 @@ func (s *ProcessStat) SetPidFilter(filter PidFilterFunc) {
 	return
 }
 
+// processes returns the map of tracked processes
+func (s *ProcessStat) processes() map[string]*PerProcessStat {
+	return s.Processes
+}
+
 // Collect walks through /proc and updates stats
 // Collect is usually called internally based on
 // parameters passed via metric context
@@ -9,10 +9,10 @@ import (
 	"math"
 	"os"
 	"os/user"
-	"path"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/square/inspect/metrics"
@@ -29,32 +29,51 @@ var linuxTicksInSec = int(C.sysconf(C._SC_CLK_TCK))
 var pageSize = int(C.sysconf(C._SC_PAGESIZE))
 var root = "/" // to make testing easy
 
+// now is a variable to make testing easy; rates are computed over
+// the time between collections it returns
+var now = time.Now
+
+// ProcessKey identifies a process across collections; pids are reused
+// but not together with the time a process started
+type ProcessKey struct {
+	Pid       string
+	StartTime uint64 // clock ticks after boot
+}
+
+// DefaultScanBudget is the number of untracked processes whose status,
+// open files, limits and IO are read per collection. /proc/<pid>/stat
+// is read for every process.
+const DefaultScanBudget = 1024
+
 // ProcessStat represents per-process cpu usage statistics
 type ProcessStat struct {
-	Processes map[string]*PerProcessStat
-	m         *metrics.MetricContext
-	x         []*PerProcessStat
-	filter    PidFilterFunc
+	// Processes which passed the filter in the last collection. Collect
+	// replaces the map instead of modifying it; use ByPid and the sorted
+	// lists to read it while collection is running.
+	Processes      map[ProcessKey]*PerProcessStat
+	all            map[ProcessKey]*PerProcessStat // every process seen; replaced by Collect
+	exited         []*PerProcessStat
+	collected      time.Time // when all was sampled
+	m              *metrics.MetricContext
+	filter         PidFilterFunc
+	smapsInterval  time.Duration
+	smapsCollected time.Time
+	scanBudget     int
+	scanNext       int          // first untracked process scanned next
+	mu             sync.RWMutex // protects Processes, all, exited and collected
+	collectMu      sync.Mutex   // serializes collections
 }
 
 // NewProcessStat registers with metriccontext and collects per-process
-// cpu statistics every Step
-// TODO: Implement better heuristics to manage load
-//   * Collect metrics for newer processes at faster rate
-//   * Slower rate for processes with negligible rate?
+// cpu statistics every Step. Every process is sampled once per Step and
+// rates are computed against the previous sample.
 func NewProcessStat(m *metrics.MetricContext, Step time.Duration) *ProcessStat {
 	c := new(ProcessStat)
 	c.m = m
 
-	c.Processes = make(map[string]*PerProcessStat, 64)
-
-	// pool for PerProcessStat objects
-	// stupid trick to avoid depending on GC to free up
-	// temporary pool
-	c.x = make([]*PerProcessStat, 1024)
-	for i := range c.x {
-		c.x[i] = NewPerProcessStat(m, "")
-	}
+	c.Processes = make(map[ProcessKey]*PerProcessStat, 64)
+	c.all = make(map[ProcessKey]*PerProcessStat, 1024)
+	c.scanBudget = DefaultScanBudget
 
 	// Assign a default filter for pids
 	c.filter = PidFilterFunc(defaultPidFilter)
@@ -72,10 +91,52 @@ func NewProcessStat(m *metrics.MetricContext, Step time.Duration) *ProcessStat {
 // SetPidFilter takes a PidFilterFunc and applies it as a filter
 // to reduce number of processes to keep track of.
 func (s *ProcessStat) SetPidFilter(filter PidFilterFunc) {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
 	s.filter = filter
 	return
 }
 
+// SetScanBudget sets number of untracked processes fully sampled per
+// collection; they are visited round robin so a process which becomes
+// interesting because of open files or limits is noticed within
+// (processes / budget) collections. Zero or less samples all of them.
+func (s *ProcessStat) SetScanBudget(n int) {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
+	s.scanBudget = n
+	return
+}
+
+// SetSmapsInterval enables collection of proportional and unique memory
+// usage from /proc/<pid>/smaps_rollup for tracked processes at most once
+// per interval. Walking page tables is expensive for large processes;
+// zero interval (the default) disables collection.
+func (s *ProcessStat) SetSmapsInterval(interval time.Duration) {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
+	s.smapsInterval = interval
+	return
+}
+
+// ByPid returns the process currently running with the input pid
+func (s *ProcessStat) ByPid(pid string) *PerProcessStat {
+	for k, o := range s.processes() {
+		if k.Pid == pid {
+			return o
+		}
+	}
+	return nil
+}
+
+// Exited returns processes seen by the previous collection which
+// were gone in the last one
+func (s *ProcessStat) Exited() []*PerProcessStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exited
+}
+
 // Return list of processes sorted by IO
 type byIOUsage []*PerProcessStat
 
@@ -87,7 +148,7 @@ func (a byIOUsage) Less(i, j int) bool { return a[i].IOUsage() > a[j].IOUsage()
 // by Memory usage
 func (s *ProcessStat) ByIOUsage() []*PerProcessStat {
 	var v []*PerProcessStat
-	for _, o := range s.Processes {
+	for _, o := range s.processes() {
 		if !math.IsNaN(o.IOUsage()) {
 			v = append(v, o)
 		}
@@ -96,104 +157,237 @@ func (s *ProcessStat) ByIOUsage() []*PerProcessStat {
 	return v
 }
 
-// CPUUsagePerCgroup returns cumulative CPU usage by cgroup
-func (s *ProcessStat) CPUUsagePerCgroup(cgroup string) float64 {
-	var ret float64
-	if !path.IsAbs(cgroup) {
-		cgroup = "/" + cgroup
+// Return list of processes sorted by time spent waiting on a runqueue
+type byRunqueueLatency []*PerProcessStat
+
+func (a byRunqueueLatency) Len() int      { return len(a) }
+func (a byRunqueueLatency) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRunqueueLatency) Less(i, j int) bool {
+	return a[i].RunqueueLatency() > a[j].RunqueueLatency()
+}
+
+// ByRunqueueLatency returns an slice of *PerProcessStat entries sorted
+// by time spent waiting on a runqueue
+func (s *ProcessStat) ByRunqueueLatency() []*PerProcessStat {
+	var v []*PerProcessStat
+	for _, o := range s.processes() {
+		if !math.IsNaN(o.RunqueueLatency()) {
+			v = append(v, o)
+		}
 	}
+	sort.Sort(byRunqueueLatency(v))
+	return v
+}
+
+// Return list of processes sorted by context switches
+type byContextSwitches []*PerProcessStat
+
+func (a byContextSwitches) Len() int      { return len(a) }
+func (a byContextSwitches) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byContextSwitches) Less(i, j int) bool {
+	return a[i].ContextSwitches() > a[j].ContextSwitches()
+}
 
-	for _, o := range s.Processes {
-		if (o.Cgroup("cpu") == cgroup) && !math.IsNaN(o.CPUUsage()) {
-			ret += o.CPUUsage()
+// ByContextSwitches returns an slice of *PerProcessStat entries sorted
+// by rate of context switches
+func (s *ProcessStat) ByContextSwitches() []*PerProcessStat {
+	var v []*PerProcessStat
+	for _, o := range s.processes() {
+		if !math.IsNaN(o.ContextSwitches()) {
+			v = append(v, o)
 		}
 	}
-	return ret
+	sort.Sort(byContextSwitches(v))
+	return v
 }
 
-// MemUsagePerCgroup returns cumulative Memory usage by cgroup
-func (s *ProcessStat) MemUsagePerCgroup(cgroup string) float64 {
-	var ret float64
-	if !path.IsAbs(cgroup) {
-		cgroup = "/" + cgroup
+// Return list of processes sorted by proportional set size
+type byPSSUsage []*PerProcessStat
+
+func (a byPSSUsage) Len() int           { return len(a) }
+func (a byPSSUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPSSUsage) Less(i, j int) bool { return a[i].PSSUsage() > a[j].PSSUsage() }
+
+// ByPSS returns an slice of *PerProcessStat entries sorted
+// by proportional set size
+func (s *ProcessStat) ByPSS() []*PerProcessStat {
+	var v []*PerProcessStat
+	for _, o := range s.processes() {
+		if !math.IsNaN(o.PSSUsage()) {
+			v = append(v, o)
+		}
 	}
-	for _, o := range s.Processes {
-		if (o.Cgroup("memory") == cgroup) && !math.IsNaN(o.MemUsage()) {
-			ret += o.MemUsage()
+	sort.Sort(byPSSUsage(v))
+	return v
+}
+
+// Return list of processes sorted by open files relative to their limit
+type byFDUsage []*PerProcessStat
+
+func (a byFDUsage) Len() int           { return len(a) }
+func (a byFDUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byFDUsage) Less(i, j int) bool { return a[i].FDUsage() > a[j].FDUsage() }
+
+// ByFDUsage returns an slice of *PerProcessStat entries sorted
+// by open files as percentage of their limit
+func (s *ProcessStat) ByFDUsage() []*PerProcessStat {
+	var v []*PerProcessStat
+	for _, o := range s.processes() {
+		if !math.IsNaN(o.FDUsage()) {
+			v = append(v, o)
 		}
 	}
-	return ret
+	sort.Sort(byFDUsage(v))
+	return v
 }
 
 // Collect walks through /proc and updates stats
 // Collect is usually called internally based on
 // parameters passed via metric context
 func (s *ProcessStat) Collect() {
-	h := s.Processes
-	for _, v := range h {
-		v.Metrics.dead = true
-	}
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
 
-	pids, err := ioutil.ReadDir(root + "proc")
+	// names are enough; stat of every entry is expensive with
+	// tens of thousands of processes
+	dir, err := os.Open(root + "proc")
 	if err != nil {
 		return
 	}
-
-	// scan 1024 processes at once to pick out the ones
-	// that are interesting
-
-	for startIdx := 0; startIdx < len(pids); startIdx += 1024 {
-		endIdx := startIdx + 1024
-		if endIdx > len(pids) {
-			endIdx = len(pids)
+	pids, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+	sort.Strings(pids)
+
+	// smaps are collected once per interval and kept
+	// until the next refresh otherwise
+	t := now()
+	refreshSmaps := s.smapsInterval > 0 &&
+		t.Sub(s.smapsCollected) >= s.smapsInterval
+	boot := bootTime()
+
+	tracked := s.processes()
+	all := make(map[ProcessKey]*PerProcessStat, len(s.all))
+	processes := make(map[ProcessKey]*PerProcessStat, len(tracked))
+	var untracked int
+	for _, pid := range pids {
+		if !isNumber(pid) {
+			continue
 		}
-
-		for _, pidstat := range s.x {
-			pidstat.Reset("?")
+		st, ok := readStat(root + "proc/" + pid + "/stat")
+		if !ok {
+			continue
 		}
-
-		s.scanProc(&pids, startIdx, endIdx)
-		time.Sleep(time.Millisecond * 1000)
-		s.scanProc(&pids, startIdx, endIdx)
-
-		for i, pidstat := range s.x {
-			if s.filter(pidstat) {
-				h[pidstat.Pid()] = pidstat
-				pidstat.Metrics.Register() // forces registration with new name
-				s.x[i] = NewPerProcessStat(s.m, "")
-				pidstat.Metrics.dead = false
+		key := ProcessKey{pid, st.startTime}
+		o, ok := s.all[key]
+		if !ok {
+			o = NewPerProcessStat(s.m, pid)
+			o.Metrics.StartTime = st.startTime
+		}
+		o.boot = boot
+		o.lastSeen = t
+		o.Metrics.setStat(st, t)
+		// tracked processes are sampled fully every time, the
+		// rest in slices of scanBudget
+		_, wasTracked := tracked[key]
+		if wasTracked || s.scanBudget <= 0 ||
+			(untracked >= s.scanNext && untracked < s.scanNext+s.scanBudget) {
+			o.Metrics.collectDetails(t)
+		}
+		if !wasTracked {
+			untracked++
+		}
+		all[key] = o
+		if s.filter(o) {
+			if refreshSmaps {
+				o.Metrics.collectSmaps()
 			}
+			processes[key] = o
 		}
 	}
+	s.scanNext += s.scanBudget
+	if s.scanNext >= untracked {
+		s.scanNext = 0
+	}
+	if refreshSmaps {
+		s.smapsCollected = t
+	}
 
-	// remove dead processes
-	for k, v := range h {
-		if v.Metrics.dead {
-			v.Metrics.Unregister()
-			delete(h, k)
+	var exited []*PerProcessStat
+	for key, o := range s.all {
+		if _, ok := all[key]; !ok {
+			exited = append(exited, o)
+		}
+	}
+	// metrics are registered by pid; unregister processes which exited
+	// or lost interest before registering new ones in case a pid was
+	// reused
+	for key, o := range tracked {
+		if _, ok := processes[key]; !ok {
+			o.Metrics.Unregister()
+		}
+	}
+	for key, o := range processes {
+		if _, ok := tracked[key]; !ok {
+			o.Metrics.Register()
 		}
 	}
+
+	s.mu.Lock()
+	s.all = all
+	s.Processes = processes
+	s.exited = exited
+	s.collected = t
+	s.mu.Unlock()
 }
 
 // unexported
-func (s *ProcessStat) scanProc(pids *[]os.FileInfo, startIdx int, endIdx int) {
-
-	pidre := regexp.MustCompile("^\\d+")
-	for i := startIdx; i < endIdx; i++ {
-		f := (*pids)[i]
-		p := f.Name()
-		if f.IsDir() && pidre.MatchString(p) {
-			pidstat := s.x[i%1024]
-			pidstat.Metrics.Pid = p
-			pidstat.Metrics.Collect()
-		}
-	}
+
+// processes returns the map of tracked processes; it is not modified
+// after being published by Collect
+func (s *ProcessStat) processes() map[ProcessKey]*PerProcessStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Processes
+}
+
+// allProcesses returns the map of every process seen by the last
+// collection whether tracked or not; it is not modified after being
+// published by Collect
+func (s *ProcessStat) allProcesses() map[ProcessKey]*PerProcessStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.all
+}
+
+// snapshot returns the map of every process seen by the last collection
+// and when it was taken
+func (s *ProcessStat) snapshot() (map[ProcessKey]*PerProcessStat, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.all, s.collected
 }
 
 // PerProcessStat represents per process statistics and methods.
 type PerProcessStat struct {
-	Metrics *PerProcessStatMetrics
-	m       *metrics.MetricContext
+	Metrics  *PerProcessStatMetrics
+	m        *metrics.MetricContext
+	boot     time.Time // when the system booted
+	lastSeen time.Time
+	attrs    procAttrs
+	attrsMu  sync.Mutex // protects attrs
+}
+
+// procAttrs caches attributes of a process which are expensive to read
+// and only change when the process execs, which changes its command name
+type procAttrs struct {
+	key     ProcessKey
+	comm    string
+	user    string            // empty until looked up
+	cmdline *string           // nil until read
+	cgroups map[string]string // cgroup by subsystem; "" for unified; nil until read
 }
 
 // NewPerProcessStat registers with metriccontext for single process
@@ -214,8 +408,7 @@ func (s *PerProcessStat) Reset(p string) {
 // Unit: # of logical CPUs
 func (s *PerProcessStat) CPUUsage() float64 {
 	o := s.Metrics
-	ratePerSec := (o.Utime.ComputeRate() + o.Stime.ComputeRate())
-	return ratePerSec / float64(linuxTicksInSec)
+	return o.perSec(&o.cpu) / float64(linuxTicksInSec)
 }
 
 // MemUsage returns amount of memory resident for this process in bytes.
@@ -227,7 +420,78 @@ func (s *PerProcessStat) MemUsage() float64 {
 // IOUsage returns cumulative bytes read/written by this process (bytes/sec)
 func (s *PerProcessStat) IOUsage() float64 {
 	o := s.Metrics
-	return o.IOReadBytes.ComputeRate() + o.IOWriteBytes.ComputeRate()
+	return o.perSec(&o.io)
+}
+
+// RunqueueLatency returns time spent by this process waiting on a
+// runqueue for a CPU. Unit: seconds per second
+func (s *PerProcessStat) RunqueueLatency() float64 {
+	o := s.Metrics
+	return o.perSec(&o.runqueue) / (1 * 1000 * 1000 * 1000)
+}
+
+// ContextSwitches returns voluntary and nonvoluntary context switches
+// done by this process (switches/sec)
+func (s *PerProcessStat) ContextSwitches() float64 {
+	o := s.Metrics
+	return o.perSec(&o.ctxtSwitches)
+}
+
+// NonvoluntaryContextSwitches returns context switches forced on this
+// process by the scheduler (switches/sec)
+func (s *PerProcessStat) NonvoluntaryContextSwitches() float64 {
+	o := s.Metrics
+	return o.perSec(&o.nvctxtSwitches)
+}
+
+// Threads returns number of threads in this process
+func (s *PerProcessStat) Threads() float64 {
+	return s.Metrics.Threads.Get()
+}
+
+// SwapUsage returns amount of memory swapped out for this process in bytes.
+func (s *PerProcessStat) SwapUsage() float64 {
+	return s.Metrics.VmSwap.Get()
+}
+
+// OpenFiles returns number of open file descriptors for this process
+func (s *PerProcessStat) OpenFiles() float64 {
+	return s.Metrics.OpenFiles.Get()
+}
+
+// FDUsage returns open file descriptors as percentage of
+// soft limit on open files (RLIMIT_NOFILE)
+func (s *PerProcessStat) FDUsage() float64 {
+	o := s.Metrics
+	return (o.OpenFiles.Get() / o.NofileSoft.Get()) * 100
+}
+
+// MemlockUsage returns locked memory as percentage of
+// soft limit on locked memory (RLIMIT_MEMLOCK)
+func (s *PerProcessStat) MemlockUsage() float64 {
+	o := s.Metrics
+	return (o.VmLck.Get() / o.MemlockSoft.Get()) * 100
+}
+
+// PSSUsage returns proportional set size of this process in bytes; shared
+// pages are divided between processes mapping them. NaN unless smaps
+// collection is enabled.
+func (s *PerProcessStat) PSSUsage() float64 {
+	return s.Metrics.Pss.Get()
+}
+
+// USSUsage returns unique set size (private pages) of this process in bytes.
+// NaN unless smaps collection is enabled.
+func (s *PerProcessStat) USSUsage() float64 {
+	o := s.Metrics
+	return o.PrivateClean.Get() + o.PrivateDirty.Get()
+}
+
+// State returns the scheduler state of this process (R, S, D, Z, T ...)
+func (s *PerProcessStat) State() string {
+	s.Metrics.mu.RLock()
+	defer s.Metrics.mu.RUnlock()
+	return s.Metrics.State
 }
 
 // Pid returns the pid for this process
@@ -235,8 +499,39 @@ func (s *PerProcessStat) Pid() string {
 	return s.Metrics.Pid
 }
 
+// Key returns pid and start time which identify this process
+func (s *PerProcessStat) Key() ProcessKey {
+	return ProcessKey{s.Metrics.Pid, s.Metrics.StartTime}
+}
+
+// StartedAt returns when this process started; zero if unknown
+func (s *PerProcessStat) StartedAt() time.Time {
+	if s.boot.IsZero() {
+		return time.Time{}
+	}
+	ticks := time.Duration(s.Metrics.StartTime) * time.Second / time.Duration(linuxTicksInSec)
+	return s.boot.Add(ticks)
+}
+
+// Lifetime returns how long this process has been running, or ran
+// until it was last seen if it exited. Unit: seconds
+func (s *PerProcessStat) Lifetime() float64 {
+	started := s.StartedAt()
+	if started.IsZero() || s.lastSeen.IsZero() {
+		return math.NaN()
+	}
+	return s.lastSeen.Sub(started).Seconds()
+}
+
 // Comm returns the command used to run for this process
 func (s *PerProcessStat) Comm() string {
+	s.Metrics.mu.RLock()
+	comm := s.Metrics.comm
+	s.Metrics.mu.RUnlock()
+	if comm != "" {
+		return comm
+	}
+
 	file, err := os.Open(root + "proc/" + s.Metrics.Pid + "/stat")
 	defer file.Close()
 
@@ -245,9 +540,13 @@ func (s *PerProcessStat) Comm() string {
 	}
 
 	scanner := bufio.NewScanner(file)
+	// command names can have spaces in them and are captured with ()
+	r := regexp.MustCompile("\\d+\\s\\((.*)\\)\\s")
 	for scanner.Scan() {
-		f := strings.Split(scanner.Text(), " ")
-		return strings.Trim(f[1], "()")
+		parts := r.FindStringSubmatch(scanner.Text())
+		if parts != nil {
+			return parts[1]
+		}
 	}
 
 	return ""
@@ -297,60 +596,125 @@ func (s *PerProcessStat) Egid() (string, error) {
 
 // User returns the username for the process - looked up by effective uid
 func (s *PerProcessStat) User() string {
+	s.attrsMu.Lock()
+	defer s.attrsMu.Unlock()
+	a := s.cachedAttrs()
+	if a.user != "" {
+		return a.user
+	}
+
 	euid, err := s.Euid()
 
-	if err != nil {
+	if err != nil || !s.sameProcess() {
 		return "?"
 	}
 
+	a.user = "?"
 	u, err := user.LookupId(euid)
-	if err != nil {
-		return "?"
+	if err == nil {
+		a.user = u.Username
 	}
 
-	return u.Username
+	return a.user
 }
 
 // Cmdline returns the complete command line used to invoke this process
+// with arguments separated by spaces; empty for kernel threads
 func (s *PerProcessStat) Cmdline() string {
+	s.attrsMu.Lock()
+	defer s.attrsMu.Unlock()
+	a := s.cachedAttrs()
+	if a.cmdline != nil {
+		return *a.cmdline
+	}
+
 	content, err := ioutil.ReadFile(root + "proc/" + s.Metrics.Pid + "/cmdline")
-	if err != nil {
-		return string(content)
+	if err != nil || !s.sameProcess() {
+		return ""
 	}
 
-	return ""
+	cmdline := strings.TrimSpace(strings.Replace(string(content), "\x00", " ", -1))
+	a.cmdline = &cmdline
+	return cmdline
 }
 
 // Cgroup returns the name of the cgroup for this process for the input
-// cgroup subsystem
+// cgroup subsystem. The unified hierarchy (cgroup v2) is used if the
+// subsystem isn't mounted separately.
 func (s *PerProcessStat) Cgroup(subsys string) string {
-	file, err := os.Open(root + "proc/" + s.Metrics.Pid + "/cgroup")
-	defer file.Close()
-
-	if err == nil {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			f := strings.Split(scanner.Text(), ":")
-			if f[1] == subsys {
-				return f[2]
-			}
+	s.attrsMu.Lock()
+	defer s.attrsMu.Unlock()
+	a := s.cachedAttrs()
+	if a.cgroups == nil {
+		cgroups := s.readCgroups()
+		if cgroups == nil || !s.sameProcess() {
+			return "/"
 		}
+		a.cgroups = cgroups
+	}
+	if cgroup, ok := a.cgroups[subsys]; ok {
+		return cgroup
+	}
+	if cgroup, ok := a.cgroups[""]; ok {
+		return cgroup
 	}
 
 	return "/"
 }
 
+// Ppid returns the pid of the parent of this process
+func (s *PerProcessStat) Ppid() string {
+	s.Metrics.mu.RLock()
+	defer s.Metrics.mu.RUnlock()
+	return s.Metrics.Ppid
+}
+
+// Session returns the session id of this process
+func (s *PerProcessStat) Session() string {
+	s.Metrics.mu.RLock()
+	defer s.Metrics.mu.RUnlock()
+	return s.Metrics.Session
+}
+
 // PerProcessStatMetrics represents metrics for the per process
 // stats collection
 type PerProcessStatMetrics struct {
-	Pid          string
-	Utime        *metrics.Counter
-	Stime        *metrics.Counter
-	Rss          *metrics.Gauge
-	IOReadBytes  *metrics.Counter
-	IOWriteBytes *metrics.Counter
-	m            *metrics.MetricContext
-	dead         bool
+	Pid                      string
+	Ppid                     string
+	Session                  string
+	State                    string
+	StartTime                uint64 // clock ticks after boot
+	Utime                    *metrics.Counter
+	Stime                    *metrics.Counter
+	Rss                      *metrics.Gauge
+	IOReadBytes              *metrics.Counter
+	IOWriteBytes             *metrics.Counter
+	RunqueueWait             *metrics.Counter // nanoseconds
+	VoluntaryCtxtSwitches    *metrics.Counter
+	NonvoluntaryCtxtSwitches *metrics.Counter
+	Threads                  *metrics.Gauge
+	VmSwap                   *metrics.Gauge
+	VmLck                    *metrics.Gauge
+	OpenFiles                *metrics.Gauge
+	NofileSoft               *metrics.Gauge
+	NofileHard               *metrics.Gauge
+	NprocSoft                *metrics.Gauge
+	NprocHard                *metrics.Gauge
+	MemlockSoft              *metrics.Gauge
+	MemlockHard              *metrics.Gauge
+	Pss                      *metrics.Gauge
+	PrivateClean             *metrics.Gauge
+	PrivateDirty             *metrics.Gauge
+	SwapPss                  *metrics.Gauge
+	m                        *metrics.MetricContext
+	comm                     string // from the last read of stat
+	children                 uint64 // cutime + cstime from the last read of stat
+	cpu                      rate   // utime + stime
+	io                       rate   // bytes read + written
+	runqueue                 rate
+	ctxtSwitches             rate
+	nvctxtSwitches           rate
+	mu                       sync.RWMutex // protects Ppid, Session, State, comm, children and rates
 }
 
 // NewPerProcessStatMetrics registers with metricscontext
@@ -369,65 +733,231 @@ func NewPerProcessStatMetrics(m *metrics.MetricContext, pid string) *PerProcessS
 
 // Register metrics with metric context
 func (s *PerProcessStatMetrics) Register() {
-	prefix := "pidstat.pid" + s.Pid
-	s.m.Register(s.Utime, prefix+"."+"Utime")
-	s.m.Register(s.Stime, prefix+"."+"Stime")
-	s.m.Register(s.Rss, prefix+"."+"Rss")
-	s.m.Register(s.IOReadBytes, prefix+"."+"IOReadBytes")
-	s.m.Register(s.IOWriteBytes, prefix+"."+"IOWriteBytes")
+	misc.RegisterMetrics(s, s.m, "pidstat.pid"+s.Pid)
 }
 
 // Unregister metrics with metriccontext
 func (s *PerProcessStatMetrics) Unregister() {
-	prefix := "pidstat.pid" + s.Pid
-	s.m.Unregister(s.Utime, prefix+"."+"Utime")
-	s.m.Unregister(s.Stime, prefix+"."+"Stime")
-	s.m.Unregister(s.Rss, prefix+"."+"Rss")
-	s.m.Unregister(s.IOReadBytes, prefix+"."+"IOReadBytes")
-	s.m.Unregister(s.IOWriteBytes, prefix+"."+"IOWriteBytes")
+	misc.UnregisterMetrics(s, s.m, "pidstat.pid"+s.Pid)
 }
 
 // Reset resets all counters and gauges to original values
 func (s *PerProcessStatMetrics) Reset(pid string) {
+	s.mu.Lock()
 	s.Pid = pid
+	s.Ppid = ""
+	s.Session = ""
+	s.State = ""
+	s.StartTime = 0
+	s.comm = ""
+	s.children = 0
+	s.cpu = rate{}
+	s.io = rate{}
+	s.runqueue = rate{}
+	s.ctxtSwitches = rate{}
+	s.nvctxtSwitches = rate{}
+	s.mu.Unlock()
 	s.Utime.Reset()
 	s.Stime.Reset()
 	s.Rss.Reset()
 	s.IOReadBytes.Reset()
 	s.IOWriteBytes.Reset()
+	s.RunqueueWait.Reset()
+	s.VoluntaryCtxtSwitches.Reset()
+	s.NonvoluntaryCtxtSwitches.Reset()
+	s.Threads.Reset()
+	s.VmSwap.Reset()
+	s.VmLck.Reset()
+	s.OpenFiles.Reset()
+	s.NofileSoft.Reset()
+	s.NofileHard.Reset()
+	s.NprocSoft.Reset()
+	s.NprocHard.Reset()
+	s.MemlockSoft.Reset()
+	s.MemlockHard.Reset()
+	s.Pss.Reset()
+	s.PrivateClean.Reset()
+	s.PrivateDirty.Reset()
+	s.SwapPss.Reset()
 }
 
 // Collect collects per process CPU/Memory/IO metrics
 func (s *PerProcessStatMetrics) Collect() {
+	st, ok := readStat(root + "proc/" + s.Pid + "/stat")
+	if !ok {
+		return
+	}
+	// pid was reused since the last sample; start over so
+	// counters of the new process are not mixed with the old
+	if s.StartTime != 0 && s.StartTime != st.startTime {
+		s.Reset(s.Pid)
+	}
+	s.StartTime = st.startTime
+	t := now()
+	s.setStat(st, t)
+	s.collectDetails(t)
+}
+
+// unexported
+
+// cachedAttrs returns attributes cached for this process after dropping
+// them if the pid was reused or the process exec'd since they were read.
+// Callers hold attrsMu.
+func (s *PerProcessStat) cachedAttrs() *procAttrs {
+	key, comm := s.Key(), s.Comm()
+	if s.attrs.key != key || s.attrs.comm != comm {
+		s.attrs = procAttrs{key: key, comm: comm}
+	}
+	return &s.attrs
+}
+
+// sameProcess returns true if the pid still belongs to this process.
+// Attributes are read by pid; one read after the process exited may
+// belong to a process which reused the pid and must not be cached.
+func (s *PerProcessStat) sameProcess() bool {
+	st, ok := readStat(root + "proc/" + s.Metrics.Pid + "/stat")
+	return ok && st.startTime == s.Metrics.StartTime
+}
 
-	file, err := os.Open(root + "proc/" + s.Pid + "/stat")
+// readCgroups returns cgroup of this process by subsystem from
+// /proc/<pid>/cgroup or nil if it can't be read
+func (s *PerProcessStat) readCgroups() map[string]string {
+	file, err := os.Open(root + "proc/" + s.Metrics.Pid + "/cgroup")
 	defer file.Close()
 
 	if err != nil {
-		return
+		return nil
 	}
 
+	cgroups := make(map[string]string)
 	scanner := bufio.NewScanner(file)
-	// command names can have spaces in them and are captured with ()
-	r := regexp.MustCompile("(\\d+)\\s\\((.*)\\)\\s(.*)")
 	for scanner.Scan() {
-		parts := r.FindStringSubmatch(scanner.Text())
-		f := strings.Split(parts[3], " ")
-		s.Utime.Set(misc.ParseUint(f[11]))
-		s.Stime.Set(misc.ParseUint(f[12]))
-		s.Rss.Set(float64(misc.ParseUint(f[21])))
+		// hierarchy-ID:controller-list:cgroup-path
+		f := strings.SplitN(scanner.Text(), ":", 3)
+		if len(f) < 3 {
+			continue
+		}
+		if f[0] == "0" && f[1] == "" {
+			cgroups[""] = f[2]
+		}
+		for _, c := range strings.Split(f[1], ",") {
+			if c != "" {
+				cgroups[c] = f[2]
+			}
+		}
+	}
+
+	return cgroups
+}
+
+// procStat represents fields of /proc/<pid>/stat used by pidstat
+type procStat struct {
+	comm, state, ppid, session string
+	utime, stime, rss          uint64
+	cutime, cstime             uint64 // of children which were waited for
+	startTime                  uint64
+}
+
+// readStat parses /proc/<pid>/stat. Command names can have spaces and
+// parentheses in them, so fields are counted after the last ')'.
+func readStat(path string) (procStat, bool) {
+	var st procStat
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return st, false
+	}
+	line := string(content)
+	start := strings.Index(line, "(")
+	end := strings.LastIndex(line, ")")
+	if start < 0 || end < start {
+		return st, false
+	}
+	f := strings.Fields(line[end+1:])
+	if len(f) < 22 {
+		return st, false
+	}
+	st.comm = line[start+1 : end]
+	st.state = f[0]
+	st.ppid = f[1]
+	st.session = f[3]
+	st.utime = misc.ParseUint(f[11])
+	st.stime = misc.ParseUint(f[12])
+	st.cutime = misc.ParseUint(f[13])
+	st.cstime = misc.ParseUint(f[14])
+	st.startTime = misc.ParseUint(f[19])
+	st.rss = misc.ParseUint(f[21])
+	return st, true
+}
+
+// rate represents a cumulative value read at the last two collections.
+// Rates are computed over the time between those collections, not
+// between reads of the rate.
+type rate struct {
+	v, prev  uint64
+	t, prevT time.Time
+}
+
+func (r *rate) set(v uint64, t time.Time) {
+	r.prev, r.prevT = r.v, r.t
+	r.v, r.t = v, t
+}
+
+// perSec returns growth of the value per second; zero until it was
+// read twice
+func (r *rate) perSec() float64 {
+	elapsed := r.t.Sub(r.prevT).Seconds()
+	if r.prevT.IsZero() || elapsed <= 0 || r.v < r.prev {
+		return 0
 	}
+	return float64(r.v-r.prev) / elapsed
+}
+
+func (s *PerProcessStatMetrics) perSec(r *rate) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return r.perSec()
+}
+
+func (s *PerProcessStatMetrics) setRate(r *rate, v uint64, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.set(v, t)
+}
+
+func (s *PerProcessStatMetrics) setStat(st procStat, t time.Time) {
+	s.mu.Lock()
+	s.comm = st.comm
+	s.children = st.cutime + st.cstime
+	s.State = st.state
+	s.Ppid = st.ppid
+	s.Session = st.session
+	s.cpu.set(st.utime+st.stime, t)
+	s.mu.Unlock()
+	s.Utime.Set(st.utime)
+	s.Stime.Set(st.stime)
+	s.Rss.Set(float64(st.rss))
+}
+
+// collectDetails reads everything but /proc/<pid>/stat
+func (s *PerProcessStatMetrics) collectDetails(t time.Time) {
+	s.collectSchedstat(t)
+	s.collectStatus(t)
+	s.collectFDs()
+	s.collectLimits()
+	s.collectIO(t)
+}
 
-	// collect IO metrics
-	// only works if we are superuser on Linux
-	file, err = os.Open(root + "proc/" + s.Pid + "/io")
+// collectIO reads bytes read/written from /proc/<pid>/io
+// only works if we are superuser on Linux
+func (s *PerProcessStatMetrics) collectIO(t time.Time) {
+	file, err := os.Open(root + "proc/" + s.Pid + "/io")
 	defer file.Close()
 
 	if err != nil {
 		return
 	}
 
-	scanner = bufio.NewScanner(file)
+	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		f := strings.Split(scanner.Text(), " ")
 		switch f[0] {
@@ -437,4 +967,172 @@ func (s *PerProcessStatMetrics) Collect() {
 			s.IOWriteBytes.Set(misc.ParseUint(f[1]))
 		}
 	}
+	s.setRate(&s.io, s.IOReadBytes.Get()+s.IOWriteBytes.Get(), t)
+}
+
+// collectSchedstat reads time spent waiting on a runqueue from
+// /proc/<pid>/schedstat. Requires CONFIG_SCHEDSTATS.
+func (s *PerProcessStatMetrics) collectSchedstat(t time.Time) {
+	content, err := ioutil.ReadFile(root + "proc/" + s.Pid + "/schedstat")
+	if err != nil {
+		return
+	}
+	// cputime_ns runqueue_wait_ns timeslices
+	f := strings.Fields(string(content))
+	if len(f) > 1 {
+		s.RunqueueWait.Set(misc.ParseUint(f[1]))
+		s.setRate(&s.runqueue, s.RunqueueWait.Get(), t)
+	}
+}
+
+// collectStatus reads context switches, thread count, swap usage
+// and scheduler state from /proc/<pid>/status
+func (s *PerProcessStatMetrics) collectStatus(t time.Time) {
+	file, err := os.Open(root + "proc/" + s.Pid + "/status")
+	defer file.Close()
+
+	if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "State:":
+			s.mu.Lock()
+			s.State = f[1]
+			s.mu.Unlock()
+		case "Threads:":
+			s.Threads.Set(float64(misc.ParseUint(f[1])))
+		case "VmSwap:":
+			s.VmSwap.Set(float64(misc.ParseUint(f[1]) * 1024))
+		case "VmLck:":
+			s.VmLck.Set(float64(misc.ParseUint(f[1]) * 1024))
+		case "voluntary_ctxt_switches:":
+			s.VoluntaryCtxtSwitches.Set(misc.ParseUint(f[1]))
+		case "nonvoluntary_ctxt_switches:":
+			s.NonvoluntaryCtxtSwitches.Set(misc.ParseUint(f[1]))
+		}
+	}
+	nv := s.NonvoluntaryCtxtSwitches.Get()
+	s.setRate(&s.ctxtSwitches, s.VoluntaryCtxtSwitches.Get()+nv, t)
+	s.setRate(&s.nvctxtSwitches, nv, t)
+}
+
+// collectFDs counts open file descriptors in /proc/<pid>/fd
+// only works for processes owned by us or if we are superuser
+func (s *PerProcessStatMetrics) collectFDs() {
+	dir, err := os.Open(root + "proc/" + s.Pid + "/fd")
+	defer dir.Close()
+
+	if err != nil {
+		return
+	}
+
+	fds, err := dir.Readdirnames(-1)
+	if err != nil {
+		return
+	}
+	s.OpenFiles.Set(float64(len(fds)))
+}
+
+// collectLimits reads soft/hard resource limits from /proc/<pid>/limits.
+// unlimited is represented as +Inf
+func (s *PerProcessStatMetrics) collectLimits() {
+	file, err := os.Open(root + "proc/" + s.Pid + "/limits")
+	defer file.Close()
+
+	if err != nil {
+		return
+	}
+
+	// names of limits have spaces in them and are separated
+	// from soft/hard limits by at least two spaces
+	r := regexp.MustCompile("^(.+?)\\s{2,}(\\S+)\\s+(\\S+)")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := r.FindStringSubmatch(scanner.Text())
+		if f == nil {
+			continue
+		}
+		switch f[1] {
+		case "Max open files":
+			s.NofileSoft.Set(parseLimit(f[2]))
+			s.NofileHard.Set(parseLimit(f[3]))
+		case "Max processes":
+			s.NprocSoft.Set(parseLimit(f[2]))
+			s.NprocHard.Set(parseLimit(f[3]))
+		case "Max locked memory":
+			s.MemlockSoft.Set(parseLimit(f[2]))
+			s.MemlockHard.Set(parseLimit(f[3]))
+		}
+	}
+}
+
+// bootTime returns when the system booted from btime in /proc/stat
+func bootTime() time.Time {
+	file, err := os.Open(root + "proc/stat")
+	defer file.Close()
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) == 2 && f[0] == "btime" {
+			return time.Unix(int64(misc.ParseUint(f[1])), 0)
+		}
+	}
+	return time.Time{}
+}
+
+func parseLimit(v string) float64 {
+	if v == "unlimited" {
+		return math.Inf(1)
+	}
+	return misc.ParseFloat(v)
+}
+
+// collectSmaps sums up memory usage across mappings from
+// /proc/<pid>/smaps_rollup, falling back to /proc/<pid>/smaps
+// on kernels older than 4.14
+func (s *PerProcessStatMetrics) collectSmaps() {
+	file, err := os.Open(root + "proc/" + s.Pid + "/smaps_rollup")
+	if err != nil {
+		file, err = os.Open(root + "proc/" + s.Pid + "/smaps")
+	}
+	defer file.Close()
+
+	if err != nil {
+		return
+	}
+
+	var pss, privateClean, privateDirty, swapPss uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "Pss:":
+			pss += misc.ParseUint(f[1])
+		case "Private_Clean:":
+			privateClean += misc.ParseUint(f[1])
+		case "Private_Dirty:":
+			privateDirty += misc.ParseUint(f[1])
+		case "SwapPss:":
+			swapPss += misc.ParseUint(f[1])
+		}
+	}
+	s.Pss.Set(float64(pss * 1024))
+	s.PrivateClean.Set(float64(privateClean * 1024))
+	s.PrivateDirty.Set(float64(privateDirty * 1024))
+	s.SwapPss.Set(float64(swapPss * 1024))
 }
@@ -3,7 +3,10 @@
 package pidstat
 
 import (
+	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
 	"testing"
 	"time"
 
@@ -11,16 +14,10 @@ import (
 )
 
 func TestPidstatCPU(t *testing.T) {
-	root = "testdata/t0/"
 	// Initialize a metric context
 	m := metrics.NewMetricContext("system")
-	pstat := NewProcessStat(m, time.Millisecond*50)
-	time.Sleep(time.Millisecond * 1000)
-	root = "testdata/t1/"
-	time.Sleep(time.Millisecond * 1000)
-	root = "testdata/t2/"
-	time.Sleep(time.Millisecond * 1000)
-	root = "testdata/t3/"
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0", "t1", "t2", "t3")
 	var expected float64 = 0.5
 	actual := pstat.ByCPUUsage()[0].CPUUsage()
 	if math.Abs(actual-expected) > 0.01 {
@@ -29,16 +26,226 @@ func TestPidstatCPU(t *testing.T) {
 }
 
 func TestPidstatMem(t *testing.T) {
-	root = "testdata/t0/"
 	// Initialize a metric context
 	m := metrics.NewMetricContext("system")
-	pstat := NewProcessStat(m, time.Millisecond*50)
-	time.Sleep(time.Millisecond * 1000)
-	root = "testdata/t1/"
-	time.Sleep(time.Millisecond * 1000)
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0", "t1")
 	var expected float64 = 1.794048e+06
 	actual := pstat.ByMemUsage()[0].MemUsage()
 	if math.Abs(actual-expected) > 0.01 {
 		t.Errorf("Mem usage for top pid: %v expected: %v", actual, expected)
 	}
 }
+
+func TestPidstatSched(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0", "t1", "t2")
+	top := pstat.ByContextSwitches()[0]
+	if top.Pid() != "9813" {
+		t.Errorf("top pid by context switches: %v expected: %v", top.Pid(), "9813")
+	}
+	top = pstat.ByRunqueueLatency()[0]
+	if top.Pid() != "9813" {
+		t.Errorf("top pid by runqueue latency: %v expected: %v", top.Pid(), "9813")
+	}
+	if top.State() != "R" {
+		t.Errorf("state for top pid: %v expected: %v", top.State(), "R")
+	}
+	if top.Threads() != 1 {
+		t.Errorf("threads for top pid: %v expected: %v", top.Threads(), 1)
+	}
+}
+
+func TestPidstatLimits(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0")
+	top := pstat.ByFDUsage()[0]
+	if top.Pid() != "9813" {
+		t.Errorf("top pid by fd usage: %v expected: %v", top.Pid(), "9813")
+	}
+	var expected = 87.5
+	actual := top.FDUsage()
+	if math.Abs(actual-expected) > 0.01 {
+		t.Errorf("fd usage for top pid: %v expected: %v", actual, expected)
+	}
+	if top.Metrics.NprocSoft.Get() != 24002 {
+		t.Errorf("nproc soft limit: %v expected: %v", top.Metrics.NprocSoft.Get(), 24002)
+	}
+}
+
+func TestPidstatPSS(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	pstat.SetSmapsInterval(time.Hour)
+	collectFixtures(pstat, "t0")
+	top := pstat.ByPSS()[0]
+	if top.Pid() != "1" {
+		t.Errorf("top pid by pss: %v expected: %v", top.Pid(), "1")
+	}
+	var expected float64 = 1700 * 1024
+	actual := top.PSSUsage()
+	if actual != expected {
+		t.Errorf("pss for top pid: %v expected: %v", actual, expected)
+	}
+	expected = 1040 * 1024
+	actual = pstat.ByPid("9813").USSUsage()
+	if actual != expected {
+		t.Errorf("uss for pid 9813: %v expected: %v", actual, expected)
+	}
+}
+
+func TestPidstatReuse(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0")
+	old := pstat.ByPid("9813")
+	if old == nil {
+		t.Fatalf("pid 9813 not found")
+	}
+	// pid 9813 exits and is reused by a process started later
+	collectFixtures(pstat, "t5")
+	if len(pstat.Processes) != 3 {
+		t.Errorf("processes: %v expected: %v", len(pstat.Processes), 3)
+	}
+	exited := pstat.Exited()
+	if len(exited) != 1 || exited[0] != old {
+		t.Errorf("exited processes: %v expected: %v", exited, []*PerProcessStat{old})
+	}
+	top := pstat.ByPid("9813")
+	if top == nil {
+		t.Fatalf("pid 9813 not found after reuse")
+	}
+	if top.Key().StartTime != 2922100000 {
+		t.Errorf("start time for pid 9813: %v expected: %v", top.Key().StartTime, 2922100000)
+	}
+	if top.Metrics.Utime.Get() != 12 {
+		t.Errorf("utime for pid 9813: %v expected: %v", top.Metrics.Utime.Get(), 12)
+	}
+	expected := time.Unix(1400000000+29221000, 0)
+	if !top.StartedAt().Equal(expected) {
+		t.Errorf("pid 9813 started at: %v expected: %v", top.StartedAt(), expected)
+	}
+	if top.Cmdline() != "perl -e 1 while(1);" {
+		t.Errorf("cmdline for pid 9813: %q expected: %q", top.Cmdline(), "perl -e 1 while(1);")
+	}
+}
+
+func TestPidstatCachedAttrs(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0")
+	p := pstat.ByPid("9813")
+	if p == nil {
+		t.Fatalf("pid 9813 not found")
+	}
+	if cgroup := p.Cgroup("cpu"); cgroup != "/batch" {
+		t.Errorf("cpu cgroup for pid 9813: %v expected: %v", cgroup, "/batch")
+	}
+	// cgroups are not read again while the process runs the same command
+	root = "testdata/missing/"
+	if cgroup := p.Cgroup("blkio"); cgroup != "/batch" {
+		t.Errorf("cached blkio cgroup for pid 9813: %v expected: %v", cgroup, "/batch")
+	}
+	// until it execs
+	p.Metrics.mu.Lock()
+	p.Metrics.comm = "sh"
+	p.Metrics.mu.Unlock()
+	if cgroup := p.Cgroup("cpu"); cgroup != "/" {
+		t.Errorf("cpu cgroup for pid 9813 after exec: %v expected: %v", cgroup, "/")
+	}
+}
+
+// clock is returned by now in tests and advanced by tick
+var clock = time.Unix(1400000000, 0)
+
+// tick advances the clock used for rates by a second
+func tick() {
+	clock = clock.Add(time.Second)
+	now = func() time.Time { return clock }
+}
+
+// collectFixtures collects once per fixture a second apart so rates are
+// computed over a second
+func collectFixtures(pstat *ProcessStat, fixtures ...string) {
+	for _, f := range fixtures {
+		tick()
+		root = "testdata/" + f + "/"
+		pstat.Collect()
+	}
+}
+
+func TestPidstatScanBudget(t *testing.T) {
+	// Initialize a metric context
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	pstat.SetScanBudget(1)
+	seen := make(map[string]*PerProcessStat)
+	pstat.SetPidFilter(PidFilterFunc(func(p *PerProcessStat) bool {
+		seen[p.Pid()] = p
+		return false
+	}))
+	sampled := func() int {
+		n := 0
+		for _, p := range seen {
+			if !math.IsNaN(p.Threads()) {
+				n++
+			}
+		}
+		return n
+	}
+	collectFixtures(pstat, "t0")
+	if len(seen) != 3 || sampled() != 1 {
+		t.Errorf("processes seen/fully sampled: %v/%v expected: %v/%v",
+			len(seen), sampled(), 3, 1)
+	}
+	collectFixtures(pstat, "t0", "t0")
+	if sampled() != 3 {
+		t.Errorf("processes fully sampled: %v expected: %v", sampled(), 3)
+	}
+	if len(pstat.Processes) != 0 {
+		t.Errorf("processes tracked: %v expected: %v", len(pstat.Processes), 0)
+	}
+}
+
+// BenchmarkCollect measures a collection over 50000 synthetic
+// processes with only the busiest ones tracked
+func BenchmarkCollect(b *testing.B) {
+	dir, err := ioutil.TempDir("", "pidstat")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for pid := 1; pid <= 50000; pid++ {
+		p := fmt.Sprintf("%s/proc/%d/", dir, pid)
+		if err := os.MkdirAll(p, 0755); err != nil {
+			b.Fatal(err)
+		}
+		stat := fmt.Sprintf("%d (worker %d) S 1 %d %d 0 -1 4202752 3545 0 10 0 "+
+			"%d 131 0 0 20 0 1 0 %d 125337600 438 18446744073709551615 "+
+			"4194304 4198148 0 0 0 0 0 128 0 0 0 0 17 0 0 0 27 0 0\n",
+			pid, pid, pid, pid, pid%1000, 1000+pid)
+		status := fmt.Sprintf("Name:\tworker %d\nState:\tS (sleeping)\n"+
+			"Threads:\t1\nVmSwap:\t0 kB\nVmLck:\t0 kB\n"+
+			"voluntary_ctxt_switches:\t%d\nnonvoluntary_ctxt_switches:\t0\n", pid, pid)
+		ioutil.WriteFile(p+"stat", []byte(stat), 0644)
+		ioutil.WriteFile(p+"status", []byte(status), 0644)
+	}
+	root = dir + "/"
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	pstat.SetPidFilter(PidFilterFunc(func(p *PerProcessStat) bool {
+		return p.CPUUsage() > 0.01
+	}))
+	pstat.Collect()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pstat.Collect()
+	}
+}
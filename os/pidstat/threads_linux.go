@@ -0,0 +1,308 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// ThreadSelector chooses processes whose threads are tracked
+type ThreadSelector struct {
+	Pids []string
+	Comm *regexp.Regexp // matched against commands of tracked processes
+	TopN int            // busiest tracked processes by cpu usage
+}
+
+// ParseThreadSelector parses a comma separated list of pids, topN
+// (say top3) and regular expressions matching command names
+func ParseThreadSelector(spec string) (ThreadSelector, error) {
+	var sel ThreadSelector
+	var patterns []string
+	for _, v := range strings.Split(spec, ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case isNumber(v):
+			sel.Pids = append(sel.Pids, v)
+		case strings.HasPrefix(v, "top") && isNumber(v[3:]):
+			sel.TopN, _ = strconv.Atoi(v[3:])
+		default:
+			patterns = append(patterns, "(?:"+v+")")
+		}
+	}
+	if len(patterns) > 0 {
+		re, err := regexp.Compile(strings.Join(patterns, "|"))
+		if err != nil {
+			return sel, err
+		}
+		sel.Comm = re
+	}
+	return sel, nil
+}
+
+// ThreadKey identifies a thread across collections
+type ThreadKey struct {
+	Tid       string
+	StartTime uint64 // clock ticks after boot
+}
+
+// ThreadStat represents per-thread cpu usage of selected processes.
+// Threads are only read for processes chosen by the selector since
+// busy servers run hundreds of them.
+type ThreadStat struct {
+	// Threads of selected processes; Collect replaces the map instead
+	// of modifying it
+	Threads   map[ThreadKey]*PerThreadStat
+	selector  ThreadSelector
+	pstat     *ProcessStat
+	m         *metrics.MetricContext
+	mu        sync.RWMutex // protects Threads and selector
+	collectMu sync.Mutex   // serializes collections
+}
+
+// NewThreadStat registers with metriccontext and collects per-thread
+// statistics of processes chosen by SetSelector every Step. Processes
+// are selected by command or cpu usage among those tracked by pstat.
+func NewThreadStat(m *metrics.MetricContext, pstat *ProcessStat, Step time.Duration) *ThreadStat {
+	s := newThreadStat(m, pstat)
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// SetSelector sets processes whose threads are tracked
+func (s *ThreadStat) SetSelector(selector ThreadSelector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selector = selector
+}
+
+// Collect reads /proc/<pid>/task/<tid>/stat and comm for every thread
+// of selected processes
+func (s *ThreadStat) Collect() {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
+
+	t := now()
+	old := s.threads()
+	threads := make(map[ThreadKey]*PerThreadStat, len(old))
+	for _, pid := range s.selectedPids() {
+		dir := root + "proc/" + pid + "/task/"
+		tids, err := readDirNames(dir)
+		if err != nil {
+			continue
+		}
+		for _, tid := range tids {
+			st, ok := readStat(dir + tid + "/stat")
+			if !ok {
+				continue
+			}
+			key := ThreadKey{tid, st.startTime}
+			o, ok := old[key]
+			if !ok {
+				o = newPerThreadStat(s.m, pid, tid)
+			}
+			comm := st.comm
+			if content, err := ioutil.ReadFile(dir + tid + "/comm"); err == nil {
+				comm = strings.TrimSpace(string(content))
+			}
+			o.set(comm, st, t)
+			threads[key] = o
+		}
+	}
+	for key, o := range old {
+		if _, ok := threads[key]; !ok {
+			o.unregister()
+		}
+	}
+	for key, o := range threads {
+		if _, ok := old[key]; !ok {
+			o.register()
+		}
+	}
+
+	s.mu.Lock()
+	s.Threads = threads
+	s.mu.Unlock()
+}
+
+// threadsByCPUUsage represents list of threads sorted by cpu usage
+type threadsByCPUUsage []*PerThreadStat
+
+func (a threadsByCPUUsage) Len() int           { return len(a) }
+func (a threadsByCPUUsage) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a threadsByCPUUsage) Less(i, j int) bool { return a[i].CPUUsage() > a[j].CPUUsage() }
+
+// ThreadsByCPUUsage returns an slice of threads of selected processes
+// sorted by cpu usage
+func (s *ThreadStat) ThreadsByCPUUsage() []*PerThreadStat {
+	var v []*PerThreadStat
+	for _, o := range s.threads() {
+		if !math.IsNaN(o.CPUUsage()) {
+			v = append(v, o)
+		}
+	}
+	sort.Stable(threadsByCPUUsage(v))
+	return v
+}
+
+// PerThreadStat represents statistics of a single thread
+type PerThreadStat struct {
+	Metrics *PerThreadStatMetrics
+	pid     string
+	tid     string
+	comm    string
+	state   string
+	cpu     rate         // utime + stime
+	mu      sync.RWMutex // protects comm, state and cpu
+	m       *metrics.MetricContext
+}
+
+// PerThreadStatMetrics represents metrics collected per thread
+type PerThreadStatMetrics struct {
+	Utime *metrics.Counter
+	Stime *metrics.Counter
+}
+
+// CPUUsage returns amount of work done by this thread in kernel/user
+// Unit: # of logical CPUs
+func (s *PerThreadStat) CPUUsage() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cpu.perSec() / float64(linuxTicksInSec)
+}
+
+// Pid returns pid of the process this thread belongs to
+func (s *PerThreadStat) Pid() string {
+	return s.pid
+}
+
+// Tid returns id of this thread
+func (s *PerThreadStat) Tid() string {
+	return s.tid
+}
+
+// Comm returns name of this thread, say "GC Thread#0"
+func (s *PerThreadStat) Comm() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.comm
+}
+
+// State returns the scheduler state of this thread (R, S, D ...)
+func (s *PerThreadStat) State() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Unexported functions
+
+func newThreadStat(m *metrics.MetricContext, pstat *ProcessStat) *ThreadStat {
+	s := new(ThreadStat)
+	s.m = m
+	s.pstat = pstat
+	s.Threads = make(map[ThreadKey]*PerThreadStat)
+	return s
+}
+
+func (s *ThreadStat) threads() map[ThreadKey]*PerThreadStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Threads
+}
+
+// selectedPids returns pids chosen by the selector
+func (s *ThreadStat) selectedPids() []string {
+	s.mu.RLock()
+	sel := s.selector
+	s.mu.RUnlock()
+	seen := make(map[string]bool)
+	var pids []string
+	add := func(pid string) {
+		if !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	for _, pid := range sel.Pids {
+		add(pid)
+	}
+	if s.pstat == nil {
+		return pids
+	}
+	if sel.Comm != nil {
+		for _, p := range s.pstat.processes() {
+			if sel.Comm.MatchString(p.Comm()) {
+				add(p.Pid())
+			}
+		}
+	}
+	for i, p := range s.pstat.ByCPUUsage() {
+		if i >= sel.TopN {
+			break
+		}
+		add(p.Pid())
+	}
+	return pids
+}
+
+func newPerThreadStat(m *metrics.MetricContext, pid, tid string) *PerThreadStat {
+	s := new(PerThreadStat)
+	s.m = m
+	s.pid = pid
+	s.tid = tid
+	s.Metrics = new(PerThreadStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, "IGNORE", false)
+	return s
+}
+
+func (s *PerThreadStat) set(comm string, st procStat, t time.Time) {
+	s.mu.Lock()
+	s.comm = comm
+	s.state = st.state
+	s.cpu.set(st.utime+st.stime, t)
+	s.mu.Unlock()
+	s.Metrics.Utime.Set(st.utime)
+	s.Metrics.Stime.Set(st.stime)
+}
+
+func (s *PerThreadStat) register() {
+	misc.RegisterMetrics(s.Metrics, s.m, "pidstat.pid"+s.pid+".tid"+s.tid)
+}
+
+func (s *PerThreadStat) unregister() {
+	misc.UnregisterMetrics(s.Metrics, s.m, "pidstat.pid"+s.pid+".tid"+s.tid)
+}
+
+// readDirNames returns names in a directory without calling stat
+// on each of them
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestThreadsByCPUUsage(t *testing.T) {
+	root = "testdata/t6/"
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	s := newThreadStat(m, pstat)
+	sel, err := ParseThreadSelector("jav.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetSelector(sel)
+	collectFixtures(pstat, "t6")
+	s.Collect()
+	tick()
+	root = "testdata/t7/"
+	pstat.Collect()
+	s.Collect()
+	threads := s.ThreadsByCPUUsage()
+	if len(threads) != 4 {
+		t.Fatalf("threads: %v expected: %v", len(threads), 4)
+	}
+	top := threads[0]
+	if top.Tid() != "502" || top.Pid() != "500" || top.Comm() != "C2 CompilerThre" {
+		t.Errorf("top thread: %v(%v) of %v expected: %v(%v) of %v",
+			top.Comm(), top.Tid(), top.Pid(), "C2 CompilerThre", "502", "500")
+	}
+	if math.Abs(top.CPUUsage()-1.0) > 0.1 {
+		t.Errorf("cpu usage of top thread: %v expected: %v", top.CPUUsage(), 1.0)
+	}
+	if threads[1].Tid() != "501" || math.Abs(threads[1].CPUUsage()-0.2) > 0.02 {
+		t.Errorf("second thread: %v at %v expected: %v at %v",
+			threads[1].Tid(), threads[1].CPUUsage(), "501", 0.2)
+	}
+}
+
+func TestParseThreadSelector(t *testing.T) {
+	sel, err := ParseThreadSelector("42,top3,java,mysqld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel.Pids) != 1 || sel.Pids[0] != "42" || sel.TopN != 3 {
+		t.Errorf("selector: %+v expected pids: %v top: %v", sel, []string{"42"}, 3)
+	}
+	if sel.Comm == nil || !sel.Comm.MatchString("mysqld") || sel.Comm.MatchString("bash") {
+		t.Errorf("command pattern: %v expected to match java and mysqld", sel.Comm)
+	}
+	if _, err := ParseThreadSelector("("); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/inspect/conf"
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// WatchRule represents processes expected to be running. A process
+// matches if it matches every criteria set.
+type WatchRule struct {
+	Name    string
+	Comm    string         // exact command name
+	Cmdline *regexp.Regexp // command line with arguments separated by spaces
+	User    string         // effective user
+	Cgroup  *regexp.Regexp // cgroup of the cpu subsystem
+	Min     int            // fewest instances expected
+	Max     int            // most instances expected; negative for no limit
+	MaxCPU  float64        // logical CPUs per instance; zero for no limit
+	MaxRSS  float64        // resident memory per instance in bytes; zero for no limit
+}
+
+// WatchResult represents processes matching a rule in the last
+// evaluation and expectations they failed
+type WatchResult struct {
+	Rule       *WatchRule
+	Metrics    *WatchResultMetrics
+	Pids       []string
+	Violations []string
+}
+
+// WatchResultMetrics represents metrics published per rule
+type WatchResultMetrics struct {
+	Count      *metrics.Gauge
+	CPU        *metrics.Gauge // logical CPUs used by all instances
+	RSS        *metrics.Gauge // bytes
+	Violations *metrics.Gauge
+}
+
+// WatchList represents rules evaluated against every process seen
+// by ProcessStat
+type WatchList struct {
+	Results []*WatchResult // in the order of rules
+	pstat   *ProcessStat
+	mu      sync.RWMutex // protects Results
+	m       *metrics.MetricContext
+}
+
+// NewWatchList registers metrics for every rule with metriccontext and
+// evaluates rules against processes seen by pstat every Step
+func NewWatchList(m *metrics.MetricContext, pstat *ProcessStat, rules []*WatchRule,
+	Step time.Duration) *WatchList {
+	s := newWatchList(m, pstat, rules)
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect matches processes against rules and checks expectations
+func (s *WatchList) Collect() {
+	procs := s.pstat.allProcesses()
+	// nothing to match against until processes were collected
+	if len(procs) == 0 {
+		return
+	}
+	s.mu.RLock()
+	results := s.Results
+	s.mu.RUnlock()
+	evaluated := make([]*WatchResult, len(results))
+	for i, o := range results {
+		evaluated[i] = o.evaluate(procs)
+	}
+	s.mu.Lock()
+	s.Results = evaluated
+	s.mu.Unlock()
+}
+
+// ByRule returns results of the last evaluation in the order of rules
+func (s *WatchList) ByRule() []*WatchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Results
+}
+
+// Violations returns results of rules with failed expectations
+func (s *WatchList) Violations() []*WatchResult {
+	var v []*WatchResult
+	for _, o := range s.ByRule() {
+		if len(o.Violations) > 0 {
+			v = append(v, o)
+		}
+	}
+	return v
+}
+
+// ReadWatchRules reads rules from a configuration file with a section
+// per rule, say
+//
+//	[mysqld]
+//	comm = mysqld
+//	min = 1
+//	max = 1
+//	max_rss = 48G
+//
+// Options are comm, cmdline (regular expression), user, cgroup (regular
+// expression), min (defaults to 1), max, max_cpu (percentage of a CPU)
+// and max_rss (bytes with an optional K, M or G suffix).
+func ReadWatchRules(path string) ([]*WatchRule, error) {
+	c, err := conf.ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sections := c.GetSections()
+	sort.Strings(sections)
+	var rules []*WatchRule
+	for _, section := range sections {
+		// options of the default section apply to every rule
+		if section == conf.DefaultSection {
+			continue
+		}
+		options, err := c.GetOptions(section)
+		if err != nil {
+			return nil, err
+		}
+		rule := &WatchRule{Name: section, Min: 1, Max: -1}
+		for _, option := range options {
+			v, err := c.GetRawString(section, option)
+			if err != nil {
+				// inherited from the default section
+				v, err = c.GetRawString(conf.DefaultSection, option)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := rule.set(option, v); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", section, option, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Unexported functions
+
+func newWatchList(m *metrics.MetricContext, pstat *ProcessStat, rules []*WatchRule) *WatchList {
+	s := new(WatchList)
+	s.m = m
+	s.pstat = pstat
+	for _, rule := range rules {
+		o := &WatchResult{Rule: rule, Metrics: new(WatchResultMetrics)}
+		// initialize all metrics and register them
+		misc.InitializeMetrics(o.Metrics, m, "pidstat.watch."+rule.Name, true)
+		s.Results = append(s.Results, o)
+	}
+	return s
+}
+
+// set parses an option of a rule from a configuration file
+func (r *WatchRule) set(option, v string) error {
+	var err error
+	switch option {
+	case "comm":
+		r.Comm = v
+	case "cmdline":
+		r.Cmdline, err = regexp.Compile(v)
+	case "user":
+		r.User = v
+	case "cgroup":
+		r.Cgroup, err = regexp.Compile(v)
+	case "min":
+		r.Min, err = strconv.Atoi(v)
+	case "max":
+		r.Max, err = strconv.Atoi(v)
+	case "max_cpu":
+		var pct float64
+		pct, err = strconv.ParseFloat(v, 64)
+		r.MaxCPU = pct / 100
+	case "max_rss":
+		r.MaxRSS, err = parseSize(v)
+	default:
+		err = fmt.Errorf("unknown option")
+	}
+	return err
+}
+
+// matches returns true if a process matches every criteria of the
+// rule; cheap criteria are checked first
+func (r *WatchRule) matches(p *PerProcessStat) bool {
+	if r.Comm != "" && p.Comm() != r.Comm {
+		return false
+	}
+	if r.Cmdline != nil && !r.Cmdline.MatchString(p.Cmdline()) {
+		return false
+	}
+	if r.User != "" && p.User() != r.User {
+		return false
+	}
+	if r.Cgroup != nil && !r.Cgroup.MatchString(p.Cgroup("cpu")) {
+		return false
+	}
+	return true
+}
+
+// evaluate returns a new result for processes matching the rule
+func (o *WatchResult) evaluate(procs map[ProcessKey]*PerProcessStat) *WatchResult {
+	r := o.Rule
+	v := &WatchResult{Rule: r, Metrics: o.Metrics}
+	var cpu, rss float64
+	var matched []*PerProcessStat
+	for _, p := range procs {
+		if r.matches(p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return misc.ParseUint(matched[i].Pid()) < misc.ParseUint(matched[j].Pid())
+	})
+	for _, p := range matched {
+		v.Pids = append(v.Pids, p.Pid())
+		pcpu, prss := p.CPUUsage(), p.MemUsage()
+		cpu += pcpu
+		rss += prss
+		if r.MaxCPU > 0 && pcpu > r.MaxCPU {
+			v.Violations = append(v.Violations, fmt.Sprintf(
+				"%s(%s) uses %3.1f%% of a CPU, limit %3.1f%%",
+				p.Comm(), p.Pid(), pcpu*100, r.MaxCPU*100))
+		}
+		if r.MaxRSS > 0 && prss > r.MaxRSS {
+			v.Violations = append(v.Violations, fmt.Sprintf(
+				"%s(%s) uses %s of memory, limit %s",
+				p.Comm(), p.Pid(), misc.ByteSize(prss), misc.ByteSize(r.MaxRSS)))
+		}
+	}
+	n := len(matched)
+	switch {
+	case n < r.Min && n == 0:
+		v.Violations = append([]string{"not running"}, v.Violations...)
+	case n < r.Min:
+		v.Violations = append([]string{fmt.Sprintf(
+			"%d running, expected at least %d", n, r.Min)}, v.Violations...)
+	case r.Max >= 0 && n > r.Max:
+		v.Violations = append([]string{fmt.Sprintf(
+			"%d running, expected at most %d", n, r.Max)}, v.Violations...)
+	}
+	v.Metrics.Count.Set(float64(n))
+	v.Metrics.CPU.Set(cpu)
+	v.Metrics.RSS.Set(rss)
+	v.Metrics.Violations.Set(float64(len(v.Violations)))
+	return v
+}
+
+// parseSize parses sizes like 512M or 2G into bytes
+func parseSize(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	mult := 1.0
+	if n := len(v); n > 0 {
+		switch strings.ToUpper(v[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	size, err := strconv.ParseFloat(v, 64)
+	return size * mult, err
+}
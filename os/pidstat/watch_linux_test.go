@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Square, Inc
+
+package pidstat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestReadWatchRules(t *testing.T) {
+	rules, err := ReadWatchRules("testdata/watch.conf")
+	if err != nil {
+		t.Fatalf("reading rules: %v", err)
+	}
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	expected := []string{"batch", "init", "perl", "sshd"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("rules: %v expected: %v", names, expected)
+	}
+	batch, perl := rules[0], rules[2]
+	if batch.Min != 1 || batch.Max != 2 || batch.MaxCPU != 0.9 {
+		t.Errorf("batch min/max/max_cpu: %v/%v/%v expected: %v/%v/%v",
+			batch.Min, batch.Max, batch.MaxCPU, 1, 2, 0.9)
+	}
+	if perl.Max != -1 || perl.MaxRSS != 1<<20 {
+		t.Errorf("perl max/max_rss: %v/%v expected: %v/%v",
+			perl.Max, perl.MaxRSS, -1, 1<<20)
+	}
+}
+
+func TestWatchList(t *testing.T) {
+	rules, err := ReadWatchRules("testdata/watch.conf")
+	if err != nil {
+		t.Fatalf("reading rules: %v", err)
+	}
+	m := metrics.NewMetricContext("system")
+	pstat := NewProcessStat(m, time.Hour)
+	collectFixtures(pstat, "t0")
+	s := newWatchList(m, pstat, rules)
+	s.Collect()
+
+	expected := map[string][]string{
+		"perl": {"2 running, expected at least 3",
+			"perl 13(9813) uses 1.71MB of memory, limit 1.00MB",
+			"perl 13(9814) uses 1.71MB of memory, limit 1.00MB"},
+		"sshd": {"not running"},
+	}
+	violations := make(map[string][]string)
+	for _, o := range s.Violations() {
+		violations[o.Rule.Name] = o.Violations
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("violations: %v expected: %v", violations, expected)
+	}
+	for _, o := range s.Results {
+		if o.Rule.Name != "batch" {
+			continue
+		}
+		if !reflect.DeepEqual(o.Pids, []string{"9813", "9814"}) {
+			t.Errorf("batch pids: %v expected: %v", o.Pids, []string{"9813", "9814"})
+		}
+		if o.Metrics.Count.Get() != 2 || o.Metrics.Violations.Get() != 0 {
+			t.Errorf("batch count/violations: %v/%v expected: %v/%v",
+				o.Metrics.Count.Get(), o.Metrics.Violations.Get(), 2, 0)
+		}
+	}
+	// cmdline, user and cgroup of processes are not read again
+	root = "testdata/missing/"
+	s.Collect()
+	violations = make(map[string][]string)
+	for _, o := range s.Violations() {
+		violations[o.Rule.Name] = o.Violations
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("violations from cached attributes: %v expected: %v", violations, expected)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package slabstat implements metrics collection related to kernel
+// slab caches
+package slabstat
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// now is a variable to make testing easy
+var now = time.Now
+
+// pageSize is the size of pages slabs are made of
+var pageSize = float64(os.Getpagesize())
+
+// DefaultTopN is the number of largest caches metrics are registered for
+const DefaultTopN = 20
+
+// DefaultWindow is the amount of history used to decide whether
+// unreclaimable slab memory grows steadily
+const DefaultWindow = 10 * time.Minute
+
+// steadyCorrelation is the correlation of unreclaimable slab memory
+// with time above which it is considered to grow steadily rather than
+// fluctuate
+const steadyCorrelation = 0.9
+
+// SlabStat represents statistics about kernel slab caches from
+// /proc/slabinfo which is usually readable only by root
+type SlabStat struct {
+	Caches      map[string]*PerCacheStat
+	Unreclaim   *metrics.Gauge // SUnreclaim from /proc/meminfo in bytes
+	topN        int
+	window      time.Duration
+	unreclaimed *misc.History
+	m           *metrics.MetricContext
+}
+
+// New registers with metriccontext and collects slab cache statistics
+// every Step
+func New(m *metrics.MetricContext, Step time.Duration) *SlabStat {
+	s := newSlabStat(m)
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// SetTopN sets number of largest caches metrics are registered for
+func (s *SlabStat) SetTopN(n int) {
+	s.topN = n
+}
+
+// SetWindow sets amount of history used to decide whether
+// unreclaimable slab memory grows steadily
+func (s *SlabStat) SetWindow(window time.Duration) {
+	s.window = window
+	s.unreclaimed.Window = window
+}
+
+// Collect parses /proc/slabinfo and SUnreclaim from /proc/meminfo
+func (s *SlabStat) Collect() {
+	t := now()
+	s.collectMeminfo(t)
+	file, err := os.Open(root + "proc/slabinfo")
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "slabinfo") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name active_objs num_objs objsize objperslab pagesperslab
+		// : tunables limit batchcount sharedfactor
+		// : slabdata active_slabs num_slabs sharedavail
+		f := strings.Fields(line)
+		if len(f) < 16 || f[6] != ":" || f[11] != ":" {
+			continue
+		}
+		o, ok := s.Caches[f[0]]
+		if !ok {
+			o = &PerCacheStat{Name: f[0]}
+			s.Caches[f[0]] = o
+		}
+		seen[f[0]] = true
+		o.update(t,
+			misc.ParseUint(f[1]), misc.ParseUint(f[2]), misc.ParseUint(f[3]),
+			float64(misc.ParseUint(f[14])*misc.ParseUint(f[5]))*pageSize)
+	}
+	for name, o := range s.Caches {
+		if !seen[name] {
+			o.unregister(s.m)
+			delete(s.Caches, name)
+		}
+	}
+	s.registerTopN()
+}
+
+// Size returns memory used by all slab caches in bytes
+func (s *SlabStat) Size() float64 {
+	var total float64
+	for _, o := range s.Caches {
+		total += o.size
+	}
+	return total
+}
+
+// UnreclaimableGrowth returns growth of unreclaimable slab memory in
+// bytes/s over the window using a least squares fit or NaN if there is
+// not enough history
+func (s *SlabStat) UnreclaimableGrowth() float64 {
+	return s.unreclaimed.Growth()
+}
+
+// IsUnreclaimableGrowing returns true if history covers the window and
+// unreclaimable slab memory grew over it closely following a line
+func (s *SlabStat) IsUnreclaimableGrowing() bool {
+	return steady(s.unreclaimed, s.window)
+}
+
+// bySize represents list of caches sorted by size
+type bySize []*PerCacheStat
+
+func (a bySize) Len() int           { return len(a) }
+func (a bySize) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySize) Less(i, j int) bool { return a[i].size > a[j].size }
+
+// BySize returns an slice of caches sorted by memory used
+func (s *SlabStat) BySize() []*PerCacheStat {
+	var v []*PerCacheStat
+	for _, o := range s.Caches {
+		v = append(v, o)
+	}
+	sort.Sort(bySize(v))
+	return v
+}
+
+// PerCacheStat represents statistics for a single slab cache
+type PerCacheStat struct {
+	Metrics    *PerCacheStatMetrics // nil unless among the largest caches
+	Name       string
+	ActiveObjs uint64
+	NumObjs    uint64
+	ObjSize    uint64 // bytes
+	size       float64
+	growth     float64
+	last       time.Time
+}
+
+// PerCacheStatMetrics represents statistics registered for the
+// largest slab caches
+type PerCacheStatMetrics struct {
+	ActiveObjs *metrics.Gauge
+	NumObjs    *metrics.Gauge
+	Size       *metrics.Gauge // bytes
+	Growth     *metrics.Gauge // bytes/s
+}
+
+// Size returns memory used by the cache in bytes
+func (s *PerCacheStat) Size() float64 {
+	return s.size
+}
+
+// Growth returns change of memory used by the cache in bytes/s since
+// the previous collection
+func (s *PerCacheStat) Growth() float64 {
+	return s.growth
+}
+
+// Usage returns percentage of allocated objects in use
+func (s *PerCacheStat) Usage() float64 {
+	if s.NumObjs == 0 {
+		return math.NaN()
+	}
+	return float64(s.ActiveObjs) / float64(s.NumObjs) * 100
+}
+
+// Unexported functions
+
+func newSlabStat(m *metrics.MetricContext) *SlabStat {
+	s := new(SlabStat)
+	s.Caches = make(map[string]*PerCacheStat)
+	s.topN = DefaultTopN
+	s.window = DefaultWindow
+	s.unreclaimed = &misc.History{Window: DefaultWindow}
+	s.m = m
+	s.Unreclaim = metrics.NewGauge()
+	m.Register(s.Unreclaim, "slabstat.Unreclaim")
+	return s
+}
+
+func (s *PerCacheStat) update(t time.Time, active, num, objsize uint64,
+	size float64) {
+	if !s.last.IsZero() {
+		if elapsed := t.Sub(s.last).Seconds(); elapsed > 0 {
+			s.growth = (size - s.size) / elapsed
+		}
+	}
+	s.last = t
+	s.ActiveObjs = active
+	s.NumObjs = num
+	s.ObjSize = objsize
+	s.size = size
+	if s.Metrics != nil {
+		s.setMetrics()
+	}
+}
+
+func (s *PerCacheStat) setMetrics() {
+	s.Metrics.ActiveObjs.Set(float64(s.ActiveObjs))
+	s.Metrics.NumObjs.Set(float64(s.NumObjs))
+	s.Metrics.Size.Set(s.size)
+	s.Metrics.Growth.Set(s.growth)
+}
+
+func (s *PerCacheStat) unregister(m *metrics.MetricContext) {
+	if s.Metrics != nil {
+		misc.UnregisterMetrics(s.Metrics, m, "slabstat."+s.Name)
+		s.Metrics = nil
+	}
+}
+
+// registerTopN registers metrics for the largest caches and removes
+// metrics of caches which are no longer among them
+func (s *SlabStat) registerTopN() {
+	for i, o := range s.BySize() {
+		if i >= s.topN {
+			o.unregister(s.m)
+			continue
+		}
+		if o.Metrics == nil {
+			o.Metrics = new(PerCacheStatMetrics)
+			misc.InitializeMetrics(o.Metrics, s.m, "slabstat."+o.Name, true)
+			o.setMetrics()
+		}
+	}
+}
+
+// collectMeminfo records SUnreclaim from /proc/meminfo which is
+// readable even if /proc/slabinfo is not
+func (s *SlabStat) collectMeminfo(t time.Time) {
+	file, err := os.Open(root + "proc/meminfo")
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 || f[0] != "SUnreclaim:" {
+			continue
+		}
+		v := float64(misc.ParseUint(f[1]))
+		if len(f) > 2 && f[2] == "kB" {
+			v *= 1024
+		}
+		s.Unreclaim.Set(v)
+		s.unreclaimed.Add(t, v)
+		return
+	}
+}
+
+// steady returns true if samples span the window and grow with a strong
+// enough correlation with time for occasional dips not to matter.
+// Samples drop out of the window as they age, so the span falls short of
+// the window by up to a step.
+func steady(h *misc.History, window time.Duration) bool {
+	if h.Span() < window*9/10 {
+		return false
+	}
+	return h.Growth() > 0 && h.Correlation() >= steadyCorrelation
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Square, Inc
+
+package slabstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+func TestSlabStat(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	defer func() { now = time.Now }()
+	pageSize = 4096
+	m := metrics.NewMetricContext("system")
+	s := newSlabStat(m)
+	s.SetTopN(2)
+	ts := start
+	now = func() time.Time { return ts }
+	root = "testdata/t0/"
+	s.Collect()
+	if len(s.Caches) != 4 {
+		t.Fatalf("slabstat: %v caches expected: 4", len(s.Caches))
+	}
+	ts = start.Add(10 * time.Second)
+	root = "testdata/t1/"
+	s.Collect()
+	if len(s.Caches) != 3 {
+		t.Fatalf("slabstat: %v caches expected: 3", len(s.Caches))
+	}
+	caches := s.BySize()
+	// 690 slabs of 8 pages, 5000 of 1 page, 3200 of 1 page
+	expected := []string{"ext4_inode_cache", "dentry", "kmalloc-64"}
+	for i, name := range expected {
+		if caches[i].Name != name {
+			t.Errorf("slabstat cache %d by size: %v expected: %v", i, caches[i].Name, name)
+		}
+	}
+	kmalloc := s.Caches["kmalloc-64"]
+	if expected := float64(3200 * 4096); kmalloc.Size() != expected {
+		t.Errorf("kmalloc-64 size: %v expected: %v", kmalloc.Size(), expected)
+	}
+	if expected := float64(2400*4096) / 10; kmalloc.Growth() != expected {
+		t.Errorf("kmalloc-64 growth: %v expected: %v", kmalloc.Growth(), expected)
+	}
+	// only the two largest caches have metrics
+	if caches[0].Metrics == nil || caches[1].Metrics == nil || kmalloc.Metrics != nil {
+		t.Errorf("slabstat metrics registered for unexpected caches")
+	}
+	if expected := float64(112640 * 1024); s.Unreclaim.Get() != expected {
+		t.Errorf("slabstat unreclaimable: %v expected: %v", s.Unreclaim.Get(), expected)
+	}
+	if s.IsUnreclaimableGrowing() {
+		t.Errorf("slabstat unreclaimable growing with too few samples")
+	}
+	for i := 0; i < 3; i++ {
+		ts = ts.Add(10 * time.Second)
+		s.Collect()
+	}
+	if growth := s.UnreclaimableGrowth(); growth <= 0 {
+		t.Errorf("slabstat unreclaimable growth: %v expected > 0", growth)
+	}
+	if s.IsUnreclaimableGrowing() {
+		t.Errorf("slabstat unreclaimable growing with history shorter than the window")
+	}
+}
+
+func TestSlabStatSteady(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	window := 10 * time.Minute
+	leak := &misc.History{Window: window}
+	noise := &misc.History{Window: window}
+	for i := 0; i <= 300; i++ {
+		ts := start.Add(time.Duration(i) * 2 * time.Second)
+		// grows 1MB/s with a dip every 30s
+		v := float64(i*2) * (1 << 20)
+		if i%15 == 0 {
+			v -= 8 * (1 << 20)
+		}
+		leak.Add(ts, v)
+		noise.Add(ts, float64(i%7)*(1<<20))
+		if i == 30 && steady(leak, window) {
+			t.Errorf("slabstat steady with a minute of history")
+		}
+	}
+	if !steady(leak, window) {
+		t.Errorf("slabstat not steady with dips: correlation %v", leak.Correlation())
+	}
+	if steady(noise, window) {
+		t.Errorf("slabstat steady on noise: correlation %v", noise.Correlation())
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2015 Square, Inc
+
+// Package softnetstat implements metrics collection for per-CPU
+// packet processing in softirq context
+package softnetstat
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/inspect/metrics"
+	"github.com/square/inspect/os/misc"
+)
+
+// to make testing easy
+var root = "/"
+
+// SoftnetStat represents per-CPU statistics from /proc/net/softnet_stat
+type SoftnetStat struct {
+	CPUs map[string]*PerCPUSoftnetStat
+	m    *metrics.MetricContext
+}
+
+// New starts metrics collection every Step and registers with
+// metricscontext
+func New(m *metrics.MetricContext, Step time.Duration) *SoftnetStat {
+	s := new(SoftnetStat)
+	s.m = m
+	s.CPUs = make(map[string]*PerCPUSoftnetStat)
+	// collect once
+	s.Collect()
+	// collect metrics every Step
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+	return s
+}
+
+// Collect populates SoftnetStat by reading /proc/net/softnet_stat.
+// Values are in hex, one line per online CPU. Recent kernels
+// include CPU index in 13th column.
+func (s *SoftnetStat) Collect() {
+	file, err := os.Open(root + "proc/net/softnet_stat")
+	defer file.Close()
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(file)
+	for i := 0; scanner.Scan(); i++ {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 3 {
+			continue
+		}
+		cpu := fmt.Sprintf("cpu%d", i)
+		if len(f) > 12 {
+			cpu = fmt.Sprintf("cpu%d", parseHex(f[12]))
+		}
+		o, ok := s.CPUs[cpu]
+		if !ok {
+			o = NewPerCPUSoftnetStat(s.m, cpu)
+			s.CPUs[cpu] = o
+		}
+		o.Processed.Set(parseHex(f[0]))
+		o.Dropped.Set(parseHex(f[1]))
+		o.TimeSqueeze.Set(parseHex(f[2]))
+	}
+}
+
+// Dropped returns packets dropped per second across all CPUs
+// because backlog queues were full
+func (s *SoftnetStat) Dropped() float64 {
+	var total float64
+	for _, o := range s.CPUs {
+		total += o.Dropped.ComputeRate()
+	}
+	return total
+}
+
+// TimeSqueeze returns number of times per second across all CPUs
+// packet processing ran out of budget with work remaining
+func (s *SoftnetStat) TimeSqueeze() float64 {
+	var total float64
+	for _, o := range s.CPUs {
+		total += o.TimeSqueeze.ComputeRate()
+	}
+	return total
+}
+
+// byDropped represents list of CPUs sorted by dropped and squeezed packets
+type byDropped []*PerCPUSoftnetStat
+
+func (a byDropped) Len() int      { return len(a) }
+func (a byDropped) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDropped) Less(i, j int) bool {
+	if a[i].Dropped.ComputeRate() != a[j].Dropped.ComputeRate() {
+		return a[i].Dropped.ComputeRate() > a[j].Dropped.ComputeRate()
+	}
+	return a[i].TimeSqueeze.ComputeRate() > a[j].TimeSqueeze.ComputeRate()
+}
+
+// ByDropped returns an slice of *PerCPUSoftnetStat entries sorted
+// by packets dropped and then by time squeezes
+func (s *SoftnetStat) ByDropped() []*PerCPUSoftnetStat {
+	var v []*PerCPUSoftnetStat
+	for _, o := range s.CPUs {
+		if !math.IsNaN(o.Dropped.ComputeRate()) &&
+			!math.IsNaN(o.TimeSqueeze.ComputeRate()) {
+			v = append(v, o)
+		}
+	}
+	sort.Sort(byDropped(v))
+	return v
+}
+
+// PerCPUSoftnetStat represents softnet statistics for a single CPU
+type PerCPUSoftnetStat struct {
+	Processed   *metrics.Counter
+	Dropped     *metrics.Counter
+	TimeSqueeze *metrics.Counter
+	Name        string
+}
+
+// NewPerCPUSoftnetStat initializes and registers metrics with
+// metriccontext for a CPU
+func NewPerCPUSoftnetStat(m *metrics.MetricContext, cpu string) *PerCPUSoftnetStat {
+	s := new(PerCPUSoftnetStat)
+	s.Name = cpu
+	// initialize all metrics and register them
+	misc.InitializeMetrics(s, m, "softnetstat."+cpu, true)
+	return s
+}
+
+// Unexported functions
+
+func parseHex(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 16, 64)
+	return v
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2015 Square, Inc
+
+package softnetstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/inspect/metrics"
+)
+
+func TestSoftnetStat(t *testing.T) {
+	root = "testdata/t0/"
+	m := metrics.NewMetricContext("system")
+	s := New(m, time.Millisecond*50)
+	time.Sleep(time.Millisecond * 1000)
+	root = "testdata/t1/"
+	time.Sleep(time.Millisecond * 100)
+	if len(s.CPUs) != 2 {
+		t.Fatalf("Expected 2 cpus, got %v", len(s.CPUs))
+	}
+	var expected uint64 = 0x74
+	if actual := s.CPUs["cpu1"].Dropped.Get(); actual != expected {
+		t.Errorf("softnet dropped: %v expected: %v", actual, expected)
+	}
+	cpus := s.ByDropped()
+	if len(cpus) != 2 || cpus[0].Name != "cpu1" {
+		t.Errorf("Expected cpu1 to drop most packets")
+	}
+}